@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/popvictor123/distributed-calc/internal/agent"
 )
 
 func main() {
 	log.Println("Starting agent...")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	agent := agent.NewAgent()
-	agent.Start()
+	agent.Start(ctx)
 }
@@ -1,38 +1,154 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/api"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"github.com/popvictor123/distributed-calc/internal/taskpb"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	repo := repository.NewRepository()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend := getEnvOrDefault("STORAGE_BACKEND", repository.BackendMemory)
+	// SNAPSHOT_PATH persists the in-memory storage across restarts.
+	snapshotPath := os.Getenv("SNAPSHOT_PATH")
+	var repo repository.Store
+	var err error
+	if backend == repository.BackendMemory && snapshotPath != "" {
+		repo, err = repository.LoadRepository(snapshotPath)
+	} else {
+		repo, err = repository.Open(backend, getEnvOrDefault("SQLITE_PATH", "calc.db"))
+	}
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", backend, err)
+	}
+	defer repo.Close()
+
+	shutdownTimeout, err := strconv.Atoi(getEnvOrDefault("SHUTDOWN_TIMEOUT_MS", "30000"))
+	if err != nil || shutdownTimeout < 0 {
+		shutdownTimeout = 30000
+	}
+
 	calc := calculator.NewCalculator()
 	svc := service.NewService(repo, calc)
 	handler := api.NewHandler(svc)
 
+	go svc.RunLeaseReaper(ctx)
+	if err := svc.ResumeWebhooks(); err != nil {
+		log.Printf("Failed to resume webhook deliveries: %v", err)
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/calculate", handler.CalculateHandler)
+		r.Post("/evaluate", handler.EvaluateHandler)
 		r.Get("/expressions", handler.GetExpressionsHandler)
 		r.Get("/expressions/{id}", handler.GetExpressionByIDHandler)
+		r.Get("/expressions/{id}/events", handler.ExpressionEventsHandler)
+		r.Delete("/expressions/{id}", handler.CancelExpressionHandler)
+		r.Get("/agents", handler.GetAgentsHandler)
+		r.Post("/agents/{id}/drain", handler.DrainAgentHandler)
+		r.Get("/events", handler.EventsHandler)
 	})
 
 	r.Route("/internal", func(r chi.Router) {
 		r.Get("/task", handler.GetTaskHandler)
 		r.Post("/task", handler.SubmitTaskResultHandler)
+		r.Get("/tasks", handler.GetTasksHandler)
+		r.Post("/tasks", handler.SubmitTaskResultsHandler)
+		r.Post("/tasks/release", handler.ReleaseTasksHandler)
+		r.Post("/agents", handler.RegisterAgentHandler)
+		r.Post("/agents/{id}/heartbeat", handler.HeartbeatAgentHandler)
 	})
 
-	log.Println("Starting orchestrator server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	grpcAddr := getEnvOrDefault("GRPC_ADDR", ":9090")
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", grpcAddr, err)
+	}
+	grpcServer := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(grpcServer, api.NewTaskServer(svc))
+	go func() {
+		log.Printf("Starting gRPC task server on %s", grpcAddr)
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("gRPC task server failed: %v", err)
+		}
+	}()
+
+	server := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		log.Printf("Starting orchestrator server on :8080 with %s storage", backend)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Orchestrator server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down orchestrator...")
+
+	// Release long-polling agents first, otherwise their requests and
+	// streams would hold up the shutdown for the whole poll wait.
+	svc.Shutdown()
+	shutdown(server, grpcServer, time.Duration(shutdownTimeout)*time.Millisecond)
+
+	if memory, ok := repo.(*repository.Repository); ok && snapshotPath != "" {
+		if err := memory.SaveSnapshot(snapshotPath); err != nil {
+			log.Printf("Failed to save snapshot to %s: %v", snapshotPath, err)
+		} else {
+			log.Printf("Saved snapshot to %s", snapshotPath)
+		}
+	}
+}
+
+// shutdown stops both servers, letting in-flight requests and streams finish
+// for up to timeout before closing them.
+func shutdown(server *http.Server, grpcServer *grpc.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Orchestrator server did not shut down cleanly: %v", err)
+		server.Close()
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("gRPC task server did not shut down in time; closing streams")
+		grpcServer.Stop()
+	}
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
 }
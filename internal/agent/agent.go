@@ -2,28 +2,60 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/operations"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 )
 
+// Version identifies the agent build in its registration. It can be set at
+// build time with -ldflags "-X .../internal/agent.Version=...".
+var Version = "dev"
+
 type Agent struct {
+	// ID identifies the agent to the orchestrator. It is generated on start,
+	// so a restarted agent registers as a new one.
+	ID              uuid.UUID
 	OrchestratorURL string
 	WorkerCount     int
-	Client          *http.Client
+	// PollWait is how long a task fetch long-polls the orchestrator before
+	// giving up; zero disables long-polling.
+	PollWait time.Duration
+	// ResultBatchSize caps how many results are submitted in one request.
+	ResultBatchSize int
+	// Transport selects the protocol used to talk to the orchestrator,
+	// TransportHTTP or TransportGRPC.
+	Transport string
+	// GRPCAddr is the address of the orchestrator's gRPC task server.
+	GRPCAddr string
+	// HeartbeatInterval is how often the agent reports that it is alive.
+	HeartbeatInterval time.Duration
+	// DrainTimeout is how long in-flight tasks may run once the agent stops
+	// taking work; tasks still running then are abandoned and released.
+	DrainTimeout time.Duration
+	Client       *http.Client
 }
 
-type TaskResult struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
-}
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+var (
+	errNoTasks       = errors.New("no tasks available")
+	errNotRegistered = errors.New("agent is not registered")
+	errDraining      = errors.New("agent is being drained")
+)
 
 func NewAgent() *Agent {
 	orchestratorURL := getEnvOrDefault("ORCHESTRATOR_URL", "http://localhost:8080")
@@ -32,12 +64,35 @@ func NewAgent() *Agent {
 	if err != nil || workerCount < 1 {
 		workerCount = 3
 	}
+	pollWait, err := time.ParseDuration(getEnvOrDefault("POLL_WAIT", "30s"))
+	if err != nil || pollWait < 0 {
+		pollWait = 30 * time.Second
+	}
+	resultBatchSize, err := strconv.Atoi(getEnvOrDefault("RESULT_BATCH_SIZE", "100"))
+	if err != nil || resultBatchSize < 1 {
+		resultBatchSize = 100
+	}
+	heartbeatInterval, err := time.ParseDuration(getEnvOrDefault("HEARTBEAT_INTERVAL", "5s"))
+	if err != nil || heartbeatInterval <= 0 {
+		heartbeatInterval = 5 * time.Second
+	}
+	drainTimeout, err := time.ParseDuration(getEnvOrDefault("DRAIN_TIMEOUT", "30s"))
+	if err != nil || drainTimeout < 0 {
+		drainTimeout = 30 * time.Second
+	}
 
 	return &Agent{
-		OrchestratorURL: orchestratorURL,
-		WorkerCount:     workerCount,
+		ID:                uuid.New(),
+		OrchestratorURL:   orchestratorURL,
+		WorkerCount:       workerCount,
+		PollWait:          pollWait,
+		ResultBatchSize:   resultBatchSize,
+		Transport:         getEnvOrDefault("TRANSPORT", TransportHTTP),
+		GRPCAddr:          getEnvOrDefault("ORCHESTRATOR_GRPC_ADDR", "localhost:9090"),
+		HeartbeatInterval: heartbeatInterval,
+		DrainTimeout:      drainTimeout,
 		Client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: pollWait + 10*time.Second,
 		},
 	}
 }
@@ -50,118 +105,472 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
-func (a *Agent) Start() {
-	log.Printf("Starting agent with %d workers, connecting to orchestrator at %s", a.WorkerCount, a.OrchestratorURL)
-	
+// Start runs the agent until ctx is cancelled or the orchestrator drains
+// it. The agent then stops taking work, lets in-flight tasks finish for up
+// to DrainTimeout, submits their results and releases the tasks it did not
+// finish back to the orchestrator.
+func (a *Agent) Start(ctx context.Context) {
+	switch a.Transport {
+	case TransportHTTP:
+		log.Printf("Starting agent with %d workers, connecting to orchestrator at %s", a.WorkerCount, a.OrchestratorURL)
+	case TransportGRPC:
+		log.Printf("Starting agent with %d workers, streaming tasks from orchestrator at %s", a.WorkerCount, a.GRPCAddr)
+	default:
+		log.Fatalf("Unknown transport %q", a.Transport)
+	}
+
+	for {
+		err := a.register()
+		if err == nil {
+			break
+		}
+		log.Printf("Error registering agent %s: %v", a.ID, err)
+		if !sleep(ctx, 1*time.Second) {
+			return
+		}
+	}
+	log.Printf("Registered as agent %s", a.ID)
+
+	// Heartbeats go on while draining, so that the agent is not declared
+	// dead and its tasks re-queued before it has finished them.
+	heartbeatCtx, stopHeartbeats := context.WithCancel(context.Background())
+	defer stopHeartbeats()
+	go a.sendHeartbeats(heartbeatCtx)
+
+	// runCtx ends when the agent stops taking work, workCtx when the drain
+	// timeout passes and workers abandon their tasks.
+	runCtx, drain := context.WithCancel(ctx)
+	defer drain()
+	workCtx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	tasks := make(chan *models.TaskResponse)
+	results := make(chan models.TaskResultRequest, a.WorkerCount)
+	// free holds one token per idle worker.
+	free := make(chan struct{}, a.WorkerCount)
+	var workers sync.WaitGroup
 	for i := 0; i < a.WorkerCount; i++ {
-		go a.worker(i)
+		free <- struct{}{}
+		workers.Add(1)
+		go func(id int) {
+			defer workers.Done()
+			a.worker(runCtx, workCtx, id, tasks, results, free)
+		}(i)
 	}
 
-	select {}
+	flushed := make(chan struct{})
+	var unsent []models.TaskResultRequest
+	flush := func() {
+		a.flushResults(unsent, results)
+		close(flushed)
+	}
+	if a.Transport == TransportGRPC {
+		unsent = a.stream(runCtx, drain, tasks, results, free)
+		// The stream is closed; the remaining results go over HTTP.
+		go flush()
+	} else {
+		go flush()
+		a.dispatch(runCtx, drain, tasks, free)
+	}
+
+	log.Printf("Draining agent %s, waiting up to %s for in-flight tasks", a.ID, a.DrainTimeout)
+	close(tasks)
+	finished := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(a.DrainTimeout):
+		log.Printf("Drain timeout exceeded, abandoning in-flight tasks")
+		abandon()
+		<-finished
+	}
+
+	close(results)
+	<-flushed
+	log.Printf("Agent %s stopped", a.ID)
 }
 
-func (a *Agent) worker(id int) {
-	log.Printf("Worker %d started", id)
-	
+// sleep waits for d and reports whether ctx is still running.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// dispatch leases as many tasks as there are idle workers and hands them
+// out, until ctx is cancelled. If the orchestrator is draining the agent it
+// calls drain.
+func (a *Agent) dispatch(ctx context.Context, drain context.CancelFunc, tasks chan<- *models.TaskResponse, free chan struct{}) {
 	for {
-		task, err := a.fetchTask()
-		if err != nil {
-			time.Sleep(1 * time.Second)
+		select {
+		case <-free:
+		case <-ctx.Done():
+			return
+		}
+		slots := 1
+	collect:
+		for slots < a.WorkerCount {
+			select {
+			case <-free:
+				slots++
+			default:
+				break collect
+			}
+		}
+
+		fetched, err := a.fetchTasks(ctx, slots)
+		if errors.Is(err, errDraining) {
+			log.Printf("Orchestrator is draining agent %s", a.ID)
+			drain()
+		}
+
+		for _, task := range fetched {
+			tasks <- task
+		}
+		for i := len(fetched); i < slots; i++ {
+			free <- struct{}{}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		// On a long-poll timeout we simply ask again right away.
+		if err != nil && !(errors.Is(err, errNoTasks) && a.PollWait > 0) {
+			sleep(ctx, 1*time.Second)
+		}
+	}
+}
+
+// worker computes tasks until tasks is closed. Tasks received once runCtx is
+// done are released unstarted; tasks still running when workCtx is
+// cancelled are abandoned and released.
+func (a *Agent) worker(runCtx, workCtx context.Context, id int, tasks <-chan *models.TaskResponse, results chan<- models.TaskResultRequest, free chan<- struct{}) {
+	log.Printf("Worker %d started", id)
+
+	for task := range tasks {
+		if runCtx.Err() != nil {
+			a.releaseTask(task)
+			free <- struct{}{}
 			continue
 		}
 
-		log.Printf("Worker %d received task %s: %v %v %v", id, task.ID, task.Arg1, task.Operation, task.Arg2)
+		log.Printf("Worker %d received task %s: %v %v", id, task.ID, task.Operation, task.Args)
 
-		result, err := a.processTask(task)
+		result := models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken}
+		value, exact, err := a.processTask(workCtx, task)
+		if workCtx.Err() != nil {
+			log.Printf("Worker %d abandoned task %s", id, task.ID)
+			a.releaseTask(task)
+			free <- struct{}{}
+			continue
+		}
 		if err != nil {
 			log.Printf("Worker %d error processing task %s: %v", id, task.ID, err)
-			continue
+			var taskErr *models.TaskError
+			if !errors.As(err, &taskErr) {
+				taskErr = models.NewTaskError(models.ErrorCodeExecutionFailed, "%v", err)
+			}
+			result.Error = taskErr
+		} else {
+			result.Result = models.Float(value)
+			result.ExactResult = exact
+		}
+
+		results <- result
+		free <- struct{}{}
+	}
+}
+
+// Retries of failed result submissions wait resultRetryBackoff, doubling
+// up to maxResultRetryBackoff. Once the agent is stopping, results are
+// dropped after finalSubmitAttempts failures; their leases then expire and
+// the tasks are computed again.
+const (
+	resultRetryBackoff    = 500 * time.Millisecond
+	maxResultRetryBackoff = 30 * time.Second
+	finalSubmitAttempts   = 3
+)
+
+// flushResults submits results as they arrive. Whatever has queued up while
+// the previous submission was in flight goes out together in the next one,
+// so batching adds no latency. A batch that fails to submit is kept and
+// retried with backoff, along with the results that arrive meanwhile.
+// pending holds results left over from the gRPC stream, which go first.
+func (a *Agent) flushResults(pending []models.TaskResultRequest, results <-chan models.TaskResultRequest) {
+	failures := 0
+	// results is set to nil once closed.
+	for results != nil || len(pending) > 0 {
+		if len(pending) == 0 {
+			result, ok := <-results
+			if !ok {
+				return
+			}
+			pending = append(pending, result)
+		}
+	collect:
+		for results != nil && len(pending) < a.ResultBatchSize {
+			select {
+			case result, ok := <-results:
+				if !ok {
+					results = nil
+					break collect
+				}
+				pending = append(pending, result)
+			default:
+				break collect
+			}
 		}
 
-		err = a.submitResult(task.ID, result)
+		batch := pending[:min(len(pending), a.ResultBatchSize)]
+		statuses, err := a.submitResults(batch)
 		if err != nil {
-			log.Printf("Worker %d error submitting result for task %s: %v", id, task.ID, err)
+			failures++
+			if results == nil && failures >= finalSubmitAttempts {
+				log.Printf("Error submitting %d results, dropping them: %v", len(pending), err)
+				return
+			}
+			delay := min(resultRetryBackoff<<min(failures-1, 16), maxResultRetryBackoff)
+			log.Printf("Error submitting %d results, retrying in %s: %v", len(batch), delay, err)
+			results = collectResults(results, &pending, delay)
 			continue
 		}
+		failures = 0
+		pending = pending[len(batch):]
 
-		log.Printf("Worker %d completed task %s with result %v", id, task.ID, result)
+		for i, status := range statuses {
+			switch {
+			case status.Status == models.SubmitStatusSuccess && batch[i].Error == nil:
+				log.Printf("Completed task %s with result %v", status.ID, batch[i].Result)
+			case status.Status == models.SubmitStatusSuccess:
+				log.Printf("Reported failure of task %s: %v", status.ID, batch[i].Error)
+			case status.Status == models.SubmitStatusCancelled:
+				log.Printf("Discarded result for task %s: expression was cancelled", status.ID)
+			default:
+				log.Printf("Result for task %s rejected (%s): %s", status.ID, status.Status, status.Error)
+			}
+		}
 	}
 }
 
-func (a *Agent) fetchTask() (*models.TaskResponse, error) {
-	resp, err := a.Client.Get(fmt.Sprintf("%s/internal/task", a.OrchestratorURL))
+func (a *Agent) fetchTasks(ctx context.Context, max int) ([]*models.TaskResponse, error) {
+	url := fmt.Sprintf("%s/internal/tasks?agent_id=%s&max=%d&wait=%s", a.OrchestratorURL, a.ID, max, a.PollWait)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("no tasks available")
+		return nil, errNoTasks
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errDraining
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var response models.GetTaskResponse
+	var response models.GetTasksResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return nil, err
 	}
 
-	return response.Task, nil
+	tasks := make([]*models.TaskResponse, len(response.Tasks))
+	for i := range response.Tasks {
+		tasks[i] = &response.Tasks[i]
+	}
+	return tasks, nil
 }
 
-func (a *Agent) processTask(task *models.TaskResponse) (float64, error) {
-	var result float64
+// register announces the agent and its capabilities to the orchestrator.
+func (a *Agent) register() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
 
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+	jsonData, err := json.Marshal(models.RegisterAgentRequest{
+		ID:          a.ID,
+		Hostname:    hostname,
+		Version:     Version,
+		WorkerCount: a.WorkerCount,
+		Operations:  operations.Types(),
+	})
+	if err != nil {
+		return err
+	}
 
-	switch task.Operation {
-	case models.OperationAddition:
-		result = task.Arg1 + task.Arg2
-	case models.OperationSubtraction:
-		result = task.Arg1 - task.Arg2
-	case models.OperationMultiplication:
-		result = task.Arg1 * task.Arg2
-	case models.OperationDivision:
-		if task.Arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
-		}
-		result = task.Arg1 / task.Arg2
-	case models.OperationValue:
-		// Just return the value
-		result = task.Arg1
-	default:
-		return 0, fmt.Errorf("unknown operation: %s", task.Operation)
+	resp, err := a.Client.Post(
+		fmt.Sprintf("%s/internal/agents", a.OrchestratorURL),
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	return result, nil
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to register: status code %d", resp.StatusCode)
+	}
+	return nil
 }
 
-func (a *Agent) submitResult(taskID uuid.UUID, result float64) error {
-	taskResult := models.TaskResultRequest{
-		ID:     taskID,
-		Result: result,
+// sendHeartbeats reports every HeartbeatInterval that the agent is alive.
+// If the orchestrator no longer knows the agent, for instance after it was
+// declared dead or the orchestrator restarted, the agent registers again.
+func (a *Agent) sendHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(a.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		err := a.heartbeat()
+		if errors.Is(err, errNotRegistered) {
+			log.Printf("Orchestrator does not know agent %s, registering again", a.ID)
+			err = a.register()
+		}
+		if err != nil {
+			log.Printf("Error sending heartbeat: %v", err)
+		}
 	}
+}
 
-	jsonData, err := json.Marshal(taskResult)
+func (a *Agent) heartbeat() error {
+	resp, err := a.Client.Post(fmt.Sprintf("%s/internal/agents/%s/heartbeat", a.OrchestratorURL, a.ID), "application/json", nil)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotRegistered
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// processTask computes a task once its simulated operation time has passed.
+// Tasks in a math/big precision also return their exact result.
+func (a *Agent) processTask(ctx context.Context, task *models.TaskResponse) (float64, string, error) {
+	if !sleep(ctx, time.Duration(task.OperationTime)*time.Millisecond) {
+		return 0, "", ctx.Err()
+	}
+
+	if task.Precision != nil && task.Precision.IsBig() {
+		exact, err := operations.ExecuteBig(task.Operation, *task.Precision, task.ExactArgs)
+		if err != nil {
+			return 0, "", err
+		}
+		_, approx, _ := operations.NormalizeBig(exact, *task.Precision)
+		return approx, exact, nil
+	}
+
+	value, err := operations.Execute(task.Operation, models.Float64s(task.Args))
+	return value, "", err
+}
+
+// releaseTask gives a leased task back to the orchestrator so that another
+// agent can compute it without waiting for the lease to expire.
+func (a *Agent) releaseTask(task *models.TaskResponse) {
+	jsonData, err := json.Marshal(models.TaskReleaseRequest{
+		Tasks: []models.TaskLease{{ID: task.ID, LeaseToken: task.LeaseToken}},
+	})
+	if err != nil {
+		log.Printf("Error releasing task %s: %v", task.ID, err)
+		return
+	}
 
 	resp, err := a.Client.Post(
-		fmt.Sprintf("%s/internal/task", a.OrchestratorURL),
+		fmt.Sprintf("%s/internal/tasks/release", a.OrchestratorURL),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
 	if err != nil {
-		return err
+		log.Printf("Error releasing task %s: %v", task.ID, err)
+		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to submit result: status code %d", resp.StatusCode)
+		log.Printf("Error releasing task %s: status code %d", task.ID, resp.StatusCode)
+		return
 	}
+	log.Printf("Released task %s", task.ID)
+}
 
-	return nil
+// collectResults appends the results that arrive within d to pending, so
+// that workers are not held up while a submission is retried. It returns
+// results, or nil if it was closed.
+func collectResults(results <-chan models.TaskResultRequest, pending *[]models.TaskResultRequest, d time.Duration) <-chan models.TaskResultRequest {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				// Receiving from nil blocks, leaving only the timer.
+				results = nil
+				continue
+			}
+			*pending = append(*pending, result)
+		case <-timer.C:
+			return results
+		}
+	}
+}
+
+// submitResults submits a batch of results and returns the per-item
+// statuses, in the order of the batch.
+func (a *Agent) submitResults(batch []models.TaskResultRequest) ([]models.TaskResultItemResponse, error) {
+	jsonData, err := json.Marshal(models.TaskResultsRequest{Results: batch})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.Client.Post(
+		fmt.Sprintf("%s/internal/tasks", a.OrchestratorURL),
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to submit results: status code %d", resp.StatusCode)
+	}
+
+	var response models.TaskResultsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Results) != len(batch) {
+		return nil, fmt.Errorf("expected %d result statuses, got %d", len(batch), len(response.Results))
+	}
+
+	return response.Results, nil
 }
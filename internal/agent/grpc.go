@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/taskpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// stream runs gRPC sessions with the orchestrator, reconnecting whenever one
+// ends, until ctx is cancelled. Within a session the orchestrator pushes
+// tasks, so there is no polling. It returns the results that were never
+// acknowledged, for submission over HTTP.
+func (a *Agent) stream(ctx context.Context, drain context.CancelFunc, tasks chan<- *models.TaskResponse, results <-chan models.TaskResultRequest, free chan struct{}) []models.TaskResultRequest {
+	conn, err := grpc.NewClient(a.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to create gRPC client for %s: %v", a.GRPCAddr, err)
+	}
+	defer conn.Close()
+	client := taskpb.NewTaskServiceClient(conn)
+	unacked := newUnackedResults()
+
+	for {
+		err := a.runSession(ctx, drain, client, tasks, results, free, unacked)
+		if ctx.Err() != nil {
+			return unacked.list()
+		}
+		log.Printf("Task stream closed: %v", err)
+		if !sleep(ctx, 1*time.Second) {
+			return unacked.list()
+		}
+	}
+}
+
+// runSession announces the agent's capacity, then hands pushed tasks to the
+// workers and streams their results back until the stream breaks or ctx is
+// cancelled. Results are kept in unacked until the orchestrator acknowledges
+// them, and those left over from an earlier session are sent again first.
+func (a *Agent) runSession(ctx context.Context, drain context.CancelFunc, client taskpb.TaskServiceClient, tasks chan<- *models.TaskResponse, results <-chan models.TaskResultRequest, free chan struct{}, unacked *unackedResults) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Work(ctx)
+	if err != nil {
+		return err
+	}
+	hello := &taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Hello{Hello: &taskpb.Hello{Capacity: int32(a.WorkerCount), AgentId: a.ID.String()}},
+	}
+	if err := stream.Send(hello); err != nil {
+		return err
+	}
+
+	sendResult := func(result models.TaskResultRequest) bool {
+		msg := &taskpb.AgentMessage{
+			Message: &taskpb.AgentMessage_Result{Result: taskpb.NewTaskResult(result)},
+		}
+		if err := stream.Send(msg); err != nil {
+			log.Printf("Error submitting result for task %s: %v", result.ID, err)
+			cancel()
+			return false
+		}
+		return true
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for _, result := range unacked.list() {
+			if !sendResult(result) {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case result := <-results:
+				unacked.add(result)
+				if !sendResult(result) {
+					return
+				}
+			}
+		}
+	}()
+	// unacked must not change once the session has ended.
+	defer func() {
+		cancel()
+		<-sent
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.Message.(type) {
+		case *taskpb.OrchestratorMessage_Task:
+			task, err := m.Task.ToModel()
+			if err != nil {
+				log.Printf("Received malformed task: %v", err)
+				continue
+			}
+			// The orchestrator never pushes more tasks than there are free
+			// workers, so this does not block for long.
+			select {
+			case <-free:
+				tasks <- task
+			case <-ctx.Done():
+				a.releaseTask(task)
+				return ctx.Err()
+			}
+
+		case *taskpb.OrchestratorMessage_Drain:
+			log.Printf("Orchestrator is draining agent %s", a.ID)
+			drain()
+			return nil
+
+		case *taskpb.OrchestratorMessage_Ack:
+			ack := m.Ack
+			if id, err := uuid.Parse(ack.GetId()); err == nil {
+				unacked.remove(id)
+			}
+			switch ack.GetStatus() {
+			case models.SubmitStatusSuccess:
+				log.Printf("Result for task %s accepted", ack.GetId())
+			case models.SubmitStatusCancelled:
+				log.Printf("Discarded result for task %s: expression was cancelled", ack.GetId())
+			default:
+				log.Printf("Result for task %s rejected (%s): %s", ack.GetId(), ack.GetStatus(), ack.GetError())
+			}
+		}
+	}
+}
+
+// unackedResults holds the results sent to the orchestrator that it has not
+// acknowledged yet. A result sent again after the orchestrator recorded it
+// is rejected harmlessly, as its lease is gone.
+type unackedResults struct {
+	mutex   sync.Mutex
+	results map[uuid.UUID]models.TaskResultRequest
+}
+
+func newUnackedResults() *unackedResults {
+	return &unackedResults{results: make(map[uuid.UUID]models.TaskResultRequest)}
+}
+
+func (u *unackedResults) add(result models.TaskResultRequest) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.results[result.ID] = result
+}
+
+func (u *unackedResults) remove(id uuid.UUID) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	delete(u.results, id)
+}
+
+func (u *unackedResults) list() []models.TaskResultRequest {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	list := make([]models.TaskResultRequest, 0, len(u.results))
+	for _, result := range u.results {
+		list = append(list, result)
+	}
+	return list
+}
@@ -0,0 +1,161 @@
+package operations
+
+import (
+	"math/big"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+var roundingModes = map[models.RoundingMode]big.RoundingMode{
+	models.RoundingNearestEven:   big.ToNearestEven,
+	models.RoundingNearestAway:   big.ToNearestAway,
+	models.RoundingToZero:        big.ToZero,
+	models.RoundingAwayFromZero:  big.AwayFromZero,
+	models.RoundingToNegativeInf: big.ToNegativeInf,
+	models.RoundingToPositiveInf: big.ToPositiveInf,
+}
+
+// newFloat returns a zero with the mantissa size and rounding of a bigfloat
+// precision, which every operation on it rounds its result to.
+func newFloat(precision models.Precision) *big.Float {
+	return new(big.Float).SetPrec(precision.Bits).SetMode(roundingModes[precision.Rounding])
+}
+
+// parseFloat decodes a bigfloat value, rounding it to the precision.
+func parseFloat(s string, precision models.Precision) (*big.Float, bool) {
+	if s == "" {
+		return nil, false
+	}
+	x, _, err := newFloat(precision).Parse(s, 10)
+	if err != nil || x.IsInf() {
+		return nil, false
+	}
+	return x, true
+}
+
+// formatFloat encodes a bigfloat value as the shortest decimal that parses
+// back to it at its precision.
+func formatFloat(x *big.Float) string {
+	return x.Text('g', -1)
+}
+
+// normalizeFloat decodes an encoded bigfloat value. Encodings are exact at
+// the precision, so they are parsed to nearest whatever its rounding.
+func normalizeFloat(s string, precision models.Precision) (*big.Float, bool) {
+	precision.Rounding = models.RoundingNearestEven
+	return parseFloat(s, precision)
+}
+
+// executeFloat runs the bigfloat counterpart of an operation. Results too
+// large for math/big are reported as domain errors rather than infinities.
+func executeFloat(op *Operation, precision models.Precision, args []string) (string, error) {
+	values := make([]*big.Float, len(args))
+	for i, arg := range args {
+		value, ok := normalizeFloat(arg, precision)
+		if !ok {
+			return "", models.NewTaskError(models.ErrorCodeInvalidArguments, "invalid bigfloat argument %q", arg)
+		}
+		values[i] = value
+	}
+
+	result, err := op.ExecuteFloat(newFloat(precision), values)
+	if err != nil {
+		return "", err
+	}
+	if result.IsInf() {
+		return "", models.NewTaskError(models.ErrorCodeDomain, "result of %s overflows the bigfloat precision", op.Type)
+	}
+	return formatFloat(result), nil
+}
+
+// powerFloat raises a bigfloat to an integer power by repeated squaring,
+// rounding after every step. Fractional exponents are not supported, as
+// math/big has no logarithms.
+func powerFloat(z *big.Float, args []*big.Float) (*big.Float, error) {
+	base, exponent := args[0], args[1]
+	if !exponent.IsInt() {
+		return nil, models.NewTaskError(models.ErrorCodeUnsupportedPrecision, "bigfloat powers need an integer exponent, got %s", formatFloat(exponent))
+	}
+	if base.Sign() == 0 && exponent.Sign() < 0 {
+		return nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "zero raised to negative power %s", formatFloat(exponent))
+	}
+
+	e, accuracy := new(big.Float).Abs(exponent).Int64()
+	if accuracy != big.Exact {
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "bigfloat power %s^%s is too large", formatFloat(base), formatFloat(exponent))
+	}
+
+	negative := base.Signbit() && e&1 == 1
+	result := new(big.Float).SetPrec(z.Prec()).SetMode(z.Mode()).SetInt64(1)
+	square := new(big.Float).SetPrec(z.Prec()).SetMode(z.Mode()).Set(base)
+	for ; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result.Mul(result, square)
+		}
+		if e > 1 {
+			square.Mul(square, square)
+		}
+		if result.IsInf() || square.IsInf() {
+			if exponent.Sign() < 0 {
+				// The reciprocal underflows.
+				return z.SetInt64(0), nil
+			}
+			return z.SetInf(negative), nil
+		}
+	}
+	if exponent.Sign() < 0 {
+		return z.Quo(z.SetInt64(1), result), nil
+	}
+	return z.Set(result), nil
+}
+
+// floorDivFloat returns the quotient of a and b rounded down, from the
+// quotient rounded to the precision, and the matching remainder, in z.
+func floorDivFloat(z, a, b *big.Float) (*big.Float, *big.Float, error) {
+	if b.Sign() == 0 {
+		return nil, nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+	}
+	q := new(big.Float).SetPrec(z.Prec()).SetMode(z.Mode()).Quo(a, b)
+	if q.IsInf() {
+		return q, q, nil
+	}
+	if !q.IsInt() {
+		// Fractional quotients are below 2^prec, so the conversion is cheap.
+		floor, _ := q.Int(nil)
+		if q.Sign() < 0 {
+			floor.Sub(floor, big.NewInt(1))
+		}
+		q.SetInt(floor)
+	}
+	r := new(big.Float).SetPrec(z.Prec()).SetMode(z.Mode()).Mul(b, q)
+	return q, z.Sub(a, r), nil
+}
+
+// roundFloat is the bigfloat counterpart of round, computed exactly and then
+// rounded to the precision.
+func roundFloat(z *big.Float, args []*big.Float) (*big.Float, error) {
+	x, digits := args[0], int64(0)
+	if len(args) == 2 {
+		if !args[1].IsInt() {
+			return nil, models.NewTaskError(models.ErrorCodeDomain, "round digits must be an integer, got %s", formatFloat(args[1]))
+		}
+		digits, _ = args[1].Int64()
+	}
+	if digits < -maxRoundDigits || digits > maxRoundDigits {
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "round digits must be between %d and %d", -maxRoundDigits, maxRoundDigits)
+	}
+
+	exp := x.MantExp(nil)
+	switch {
+	case x.IsInt() && digits >= 0:
+		return z.Set(x), nil
+	case exp < -maxExactBits:
+		// Far below the smallest digit kept.
+		return z.SetInt64(0), nil
+	case exp > maxExactBits:
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "%s is too large to round", formatFloat(x))
+	}
+
+	exact, _ := x.Rat(nil)
+	return z.SetRat(roundDecimal(exact, int(digits))), nil
+}
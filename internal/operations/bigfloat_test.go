@@ -0,0 +1,33 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+func TestExecuteFloat(t *testing.T) {
+	bigfloat := func(bits uint, rounding models.RoundingMode) models.Precision {
+		return models.Precision{Mode: models.PrecisionBigFloat, Bits: bits, Rounding: rounding}
+	}
+	precise := bigfloat(models.DefaultBigFloatBits, models.RoundingNearestEven)
+
+	runBigTests(t, []bigTest{
+		{"add", precise, models.OperationAddition, []string{"0.1", "0.2"}, "0.3", ""},
+		{"floor divide", precise, models.OperationFloorDivision, []string{"-7", "2"}, "-4", ""},
+		{"modulo", precise, models.OperationModulo, []string{"-7", "2"}, "1", ""},
+		{"power", precise, models.OperationPower, []string{"10", "100"}, "1e+100", ""},
+		{"64 bits", bigfloat(64, models.RoundingNearestEven), models.OperationDivision, []string{"1", "3"}, "0.33333333333333333334", ""},
+		// 1/3 in 8 bits lies between 0.33203125 and 0.333984375.
+		{"nearest even", bigfloat(8, models.RoundingNearestEven), models.OperationDivision, []string{"1", "3"}, "0.334", ""},
+		{"to zero", bigfloat(8, models.RoundingToZero), models.OperationDivision, []string{"-1", "3"}, "-0.332", ""},
+		{"away from zero", bigfloat(8, models.RoundingAwayFromZero), models.OperationDivision, []string{"1", "3"}, "0.334", ""},
+		{"to negative inf", bigfloat(8, models.RoundingToNegativeInf), models.OperationDivision, []string{"1", "3"}, "0.332", ""},
+		{"to negative inf negative", bigfloat(8, models.RoundingToNegativeInf), models.OperationDivision, []string{"-1", "3"}, "-0.334", ""},
+		{"to positive inf negative", bigfloat(8, models.RoundingToPositiveInf), models.OperationDivision, []string{"-1", "3"}, "-0.332", ""},
+		{"divide by zero", precise, models.OperationDivision, []string{"1", "0"}, "", models.ErrorCodeDivisionByZero},
+		{"zero by zero", precise, models.OperationDivision, []string{"0", "0"}, "", models.ErrorCodeDivisionByZero},
+		{"fractional exponent", precise, models.OperationPower, []string{"2", "0.5"}, "", models.ErrorCodeUnsupportedPrecision},
+		{"huge exponent", precise, models.OperationPower, []string{"10", "1e40"}, "", models.ErrorCodeDomain},
+	})
+}
@@ -0,0 +1,227 @@
+package operations
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// maxExactBits bounds the size of exact powers, which otherwise grow without
+// limit, and maxRoundDigits the digits of round in every precision.
+const (
+	maxExactBits   = 1 << 20
+	maxRoundDigits = 1000
+)
+
+// SupportsPrecision reports whether the operation can be evaluated in the
+// given precision.
+func (o *Operation) SupportsPrecision(precision models.Precision) bool {
+	switch {
+	case precision.IsExact():
+		return o.ExecuteRat != nil
+	case precision.IsInteger():
+		return o.ExecuteInt != nil
+	case precision.Mode == models.PrecisionBigFloat:
+		return o.ExecuteFloat != nil
+	default:
+		return true
+	}
+}
+
+// ExecuteBig runs an operation in a math/big precision on arguments encoded
+// as strings and returns the encoded result. Failures are returned as
+// *models.TaskError.
+func ExecuteBig(opType models.OperationType, precision models.Precision, args []string) (string, error) {
+	op, ok := registry[opType]
+	if !ok {
+		return "", models.NewTaskError(models.ErrorCodeUnknownOperation, "unknown operation type: %s", opType)
+	}
+	if !op.AcceptsArgs(len(args)) {
+		return "", models.NewTaskError(models.ErrorCodeInvalidArguments, "operation %s does not accept %d arguments", opType, len(args))
+	}
+	if !precision.IsBig() || !op.SupportsPrecision(precision) {
+		return "", models.NewTaskError(models.ErrorCodeUnsupportedPrecision, "operation %s is not supported in the %s precision", opType, precision.Mode)
+	}
+	if precision.Mode == models.PrecisionBigFloat {
+		return executeFloat(op, precision, args)
+	}
+	if precision.IsInteger() {
+		return executeInt(op, precision, args)
+	}
+
+	values := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		value, ok := ParseExact(arg)
+		if !ok {
+			return "", models.NewTaskError(models.ErrorCodeInvalidArguments, "invalid exact argument %q", arg)
+		}
+		values[i] = value
+	}
+
+	result, err := op.ExecuteRat(values)
+	if err != nil {
+		return "", err
+	}
+	return FormatExact(result, precision), nil
+}
+
+// ParseLiteral decodes a number as written in an expression in a math/big
+// precision, rounding it as the precision does, and returns its encoding and
+// float64 approximation, which saturates at ±math.MaxFloat64. It reports
+// false for text that is not a finite number, and in the integer precisions
+// for numbers that are not whole or out of range.
+func ParseLiteral(literal string, precision models.Precision) (string, float64, bool) {
+	if precision.IsInteger() {
+		value, ok := parseInt(literal, precision)
+		if !ok {
+			return "", 0, false
+		}
+		approx, _ := new(big.Float).SetInt(value).Float64()
+		return value.String(), saturate(approx), true
+	}
+	if precision.Mode == models.PrecisionBigFloat {
+		value, ok := parseFloat(literal, precision)
+		if !ok {
+			return "", 0, false
+		}
+		approx, _ := value.Float64()
+		return formatFloat(value), saturate(approx), true
+	}
+
+	value, ok := ParseExact(literal)
+	if !ok {
+		return "", 0, false
+	}
+	encoded := FormatExact(value, precision)
+	value, _ = ParseExact(encoded)
+	approx, _ := value.Float64()
+	return encoded, saturate(approx), true
+}
+
+// NormalizeBig validates a value encoded in a math/big precision, such as an
+// agent's result, and returns its canonical encoding and float64
+// approximation.
+func NormalizeBig(value string, precision models.Precision) (string, float64, bool) {
+	if precision.Mode == models.PrecisionBigFloat {
+		x, ok := normalizeFloat(value, precision)
+		if !ok {
+			return "", 0, false
+		}
+		approx, _ := x.Float64()
+		return formatFloat(x), saturate(approx), true
+	}
+	return ParseLiteral(value, precision)
+}
+
+// saturate keeps the float64 approximation of a value beyond the float64
+// range finite, as the value itself is carried by its encoding.
+func saturate(approx float64) float64 {
+	switch {
+	case math.IsInf(approx, 1):
+		return math.MaxFloat64
+	case math.IsInf(approx, -1):
+		return -math.MaxFloat64
+	default:
+		return approx
+	}
+}
+
+// ParseExact decodes an exact value: a fraction such as "1/3" or a decimal
+// number.
+func ParseExact(s string) (*big.Rat, bool) {
+	if s == "" {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// FormatExact encodes an exact value: as a fraction in lowest terms, or an
+// integer, in the rational precision and as a decimal with exactly Scale
+// places, rounded half away from zero, in the decimal precision.
+func FormatExact(x *big.Rat, precision models.Precision) string {
+	if precision.Mode == models.PrecisionDecimal {
+		return roundDecimal(x, precision.Scale).FloatString(precision.Scale)
+	}
+	return x.RatString()
+}
+
+// roundDecimal rounds x half away from zero to the given number of decimal
+// places. Negative places round to tens, hundreds and so on.
+func roundDecimal(x *big.Rat, places int) *big.Rat {
+	if places >= 0 {
+		rounded, _ := new(big.Rat).SetString(x.FloatString(places))
+		return rounded
+	}
+	unit := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-places)), nil))
+	rounded, _ := new(big.Rat).SetString(new(big.Rat).Quo(x, unit).FloatString(0))
+	return rounded.Mul(rounded, unit)
+}
+
+// powerRat raises a fraction to an integer power. Fractional exponents have
+// irrational results in general and are not supported.
+func powerRat(args []*big.Rat) (*big.Rat, error) {
+	base, exponent := args[0], args[1]
+	if !exponent.IsInt() {
+		return nil, models.NewTaskError(models.ErrorCodeUnsupportedPrecision, "exact powers need an integer exponent, got %s", exponent.RatString())
+	}
+	if base.Sign() == 0 && exponent.Sign() < 0 {
+		return nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "zero raised to negative power %s", exponent.RatString())
+	}
+
+	// Powers of 0, 1 and -1 stay small whatever the exponent.
+	if base.Sign() == 0 {
+		if exponent.Sign() == 0 {
+			return big.NewRat(1, 1), nil
+		}
+		return new(big.Rat), nil
+	}
+	if base.Num().CmpAbs(base.Denom()) == 0 {
+		if base.Sign() < 0 && exponent.Num().Bit(0) == 1 {
+			return big.NewRat(-1, 1), nil
+		}
+		return big.NewRat(1, 1), nil
+	}
+
+	// Dividing rather than multiplying keeps the bound from overflowing
+	// int64; other bases have at least 2 bits.
+	e := new(big.Int).Abs(exponent.Num())
+	bits := max(base.Num().BitLen(), base.Denom().BitLen())
+	if !e.IsInt64() || e.Int64() > maxExactBits/int64(bits) {
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "exact power %s^%s is too large", base.RatString(), exponent.RatString())
+	}
+
+	result := new(big.Rat).SetFrac(new(big.Int).Exp(base.Num(), e, nil), new(big.Int).Exp(base.Denom(), e, nil))
+	if exponent.Sign() < 0 {
+		result.Inv(result)
+	}
+	return result, nil
+}
+
+// floorDivRat returns the quotient of a and b rounded down and the matching
+// remainder.
+func floorDivRat(a, b *big.Rat) (*big.Rat, *big.Rat, error) {
+	if b.Sign() == 0 {
+		return nil, nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+	}
+	quotient := new(big.Rat).Quo(a, b)
+	floor, _, _ := floorDivInt(quotient.Num(), quotient.Denom())
+	q := new(big.Rat).SetInt(floor)
+	r := new(big.Rat).Sub(a, new(big.Rat).Mul(b, q))
+	return q, r, nil
+}
+
+// roundRat is the exact counterpart of round.
+func roundRat(args []*big.Rat) (*big.Rat, error) {
+	x, digits := args[0], int64(0)
+	if len(args) == 2 {
+		if !args[1].IsInt() || !args[1].Num().IsInt64() {
+			return nil, models.NewTaskError(models.ErrorCodeDomain, "round digits must be an integer, got %s", args[1].RatString())
+		}
+		digits = args[1].Num().Int64()
+	}
+	if digits < -maxRoundDigits || digits > maxRoundDigits {
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "round digits must be between %d and %d", -maxRoundDigits, maxRoundDigits)
+	}
+	return roundDecimal(x, int(digits)), nil
+}
@@ -0,0 +1,154 @@
+package operations
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// taskErrorCode returns the code of a *models.TaskError, or "" for nil.
+func taskErrorCode(t *testing.T, err error) models.TaskErrorCode {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var taskErr *models.TaskError
+	if !errors.As(err, &taskErr) {
+		t.Fatalf("error %v is not a *models.TaskError", err)
+	}
+	return taskErr.Code
+}
+
+// bigTest is an operation in a math/big precision and its encoded result,
+// or the code of the error it fails with.
+type bigTest struct {
+	name      string
+	precision models.Precision
+	op        models.OperationType
+	args      []string
+	want      string
+	code      models.TaskErrorCode
+}
+
+func runBigTests(t *testing.T, tests []bigTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExecuteBig(tt.op, tt.precision, tt.args)
+			if code := taskErrorCode(t, err); code != tt.code {
+				t.Fatalf("%s %q: error code %q (%v), want %q", tt.op, tt.args, code, err, tt.code)
+			}
+			if got != tt.want {
+				t.Errorf("%s %q = %q, want %q", tt.op, tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteExact(t *testing.T) {
+	rational := models.Precision{Mode: models.PrecisionRational}
+	decimal := models.Precision{Mode: models.PrecisionDecimal, Scale: 2}
+	whole := models.Precision{Mode: models.PrecisionDecimal, Scale: 0}
+
+	runBigTests(t, []bigTest{
+		{"rational add", rational, models.OperationAddition, []string{"1/3", "1/6"}, "1/2", ""},
+		{"rational add decimals", rational, models.OperationAddition, []string{"0.1", "0.2"}, "3/10", ""},
+		{"rational subtract", rational, models.OperationSubtraction, []string{"1", "1/3"}, "2/3", ""},
+		{"rational multiply", rational, models.OperationMultiplication, []string{"2/3", "3/4"}, "1/2", ""},
+		{"rational divide", rational, models.OperationDivision, []string{"-2", "3"}, "-2/3", ""},
+		{"rational floor divide", rational, models.OperationFloorDivision, []string{"-7", "2"}, "-4", ""},
+		{"rational modulo", rational, models.OperationModulo, []string{"-7", "2"}, "1", ""},
+		{"rational fractional modulo", rational, models.OperationModulo, []string{"7/2", "1"}, "1/2", ""},
+		{"rational negate", rational, models.OperationNegation, []string{"5/2"}, "-5/2", ""},
+		{"rational power", rational, models.OperationPower, []string{"2/3", "-2"}, "9/4", ""},
+		{"rational divide by zero", rational, models.OperationDivision, []string{"1", "0"}, "", models.ErrorCodeDivisionByZero},
+		{"rational sqrt", rational, models.OperationSqrt, []string{"4"}, "", models.ErrorCodeUnsupportedPrecision},
+		{"rational invalid argument", rational, models.OperationAddition, []string{"1/0", "1"}, "", models.ErrorCodeInvalidArguments},
+		// The decimal precision rounds half away from zero to its scale.
+		{"decimal add", decimal, models.OperationAddition, []string{"0.1", "0.2"}, "0.30", ""},
+		{"decimal divide", decimal, models.OperationDivision, []string{"2", "3"}, "0.67", ""},
+		{"decimal divide negative", decimal, models.OperationDivision, []string{"-2", "3"}, "-0.67", ""},
+		{"decimal half away from zero", decimal, models.OperationDivision, []string{"1", "8"}, "0.13", ""},
+		{"decimal half away from zero negative", decimal, models.OperationDivision, []string{"-1", "8"}, "-0.13", ""},
+		{"decimal floor divide", decimal, models.OperationFloorDivision, []string{"-7", "2"}, "-4.00", ""},
+		{"decimal divide by zero", decimal, models.OperationDivision, []string{"1", "0"}, "", models.ErrorCodeDivisionByZero},
+		{"scale 0 divide", whole, models.OperationDivision, []string{"2", "3"}, "1", ""},
+		{"scale 0 negate", whole, models.OperationNegation, []string{"5/2"}, "-3", ""},
+	})
+}
+
+func TestParseLiteralExact(t *testing.T) {
+	rational := models.Precision{Mode: models.PrecisionRational}
+	decimal := models.Precision{Mode: models.PrecisionDecimal, Scale: 2}
+
+	tests := []struct {
+		precision models.Precision
+		literal   string
+		want      string
+		approx    float64
+	}{
+		{rational, "0.1", "1/10", 0.1},
+		{rational, "2.50", "5/2", 2.5},
+		{rational, "1e-3", "1/1000", 0.001},
+		{decimal, "0.125", "0.13", 0.13},
+		{decimal, "-0.125", "-0.13", -0.13},
+		{decimal, "7", "7.00", 7},
+		// Values beyond float64 are kept, with a saturated approximation.
+		{rational, "1e400", "1" + strings.Repeat("0", 400), math.MaxFloat64},
+		{decimal, "-1e400", "-1" + strings.Repeat("0", 400) + ".00", -math.MaxFloat64},
+	}
+
+	for _, tt := range tests {
+		got, approx, ok := ParseLiteral(tt.literal, tt.precision)
+		if !ok || got != tt.want || approx != tt.approx {
+			t.Errorf("ParseLiteral(%q, %s) = %q, %v, %v, want %q, %v", tt.literal, tt.precision.Mode, got, approx, ok, tt.want, tt.approx)
+		}
+	}
+}
+
+func TestPowerRatBounds(t *testing.T) {
+	rational := models.Precision{Mode: models.PrecisionRational}
+	decimal := models.Precision{Mode: models.PrecisionDecimal, Scale: 2}
+
+	tests := []struct {
+		name      string
+		precision models.Precision
+		base      string
+		exponent  string
+		want      string
+		code      models.TaskErrorCode
+	}{
+		{"small", rational, "2/3", "3", "8/27", ""},
+		{"negative exponent", rational, "2/3", "-2", "9/4", ""},
+		{"decimal", decimal, "1.5", "2", "2.25", ""},
+		{"zero to zero", rational, "0", "0", "1", ""},
+		{"zero to huge", rational, "0", "9223372036854775807", "0", ""},
+		{"one to huge", rational, "1", "9223372036854775807", "1", ""},
+		{"minus one to huge odd", rational, "-1", "-9223372036854775807", "-1", ""},
+		{"minus one to huge even", decimal, "-1", "9223372036854775806", "1.00", ""},
+		{"at the bound", rational, "3", "524288", "", ""},
+		{"past the bound", rational, "3", "524289", "", models.ErrorCodeDomain},
+		// 2·(2^63-1) wraps int64 negative in e·bits.
+		{"product wraps", rational, "3", "9223372036854775807", "", models.ErrorCodeDomain},
+		{"product wraps decimal", decimal, "3", "9223372036854775807", "", models.ErrorCodeDomain},
+		{"fraction product wraps", rational, "1/3", "-9223372036854775807", "", models.ErrorCodeDomain},
+		{"beyond int64 exponent", rational, "2", "9223372036854775808", "", models.ErrorCodeDomain},
+		{"fractional exponent", rational, "2", "1/2", "", models.ErrorCodeUnsupportedPrecision},
+		{"zero to negative", rational, "0", "-1", "", models.ErrorCodeDivisionByZero},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExecuteBig(models.OperationPower, tt.precision, []string{tt.base, tt.exponent})
+			if code := taskErrorCode(t, err); code != tt.code {
+				t.Fatalf("%s ^ %s: error code %q (%v), want %q", tt.base, tt.exponent, code, err, tt.code)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("%s ^ %s = %s, want %s", tt.base, tt.exponent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package operations
+
+import (
+	"math/big"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// parseInt decodes an integer value, reporting false for text that is not a
+// whole number or, in the integer precision, one out of the int64 range.
+func parseInt(s string, precision models.Precision) (*big.Int, bool) {
+	value, ok := ParseExact(s)
+	if !ok || !value.IsInt() {
+		return nil, false
+	}
+	if precision.Mode == models.PrecisionInteger && !value.Num().IsInt64() {
+		return nil, false
+	}
+	return value.Num(), true
+}
+
+// IsInteger reports whether a number as written in an expression is a whole
+// number, such as 42, 1e3 or 10.0.
+func IsInteger(literal string) bool {
+	value, ok := ParseExact(literal)
+	return ok && value.IsInt()
+}
+
+// executeInt runs the integer counterpart of an operation. In the integer
+// precision results out of the int64 range are overflow errors.
+func executeInt(op *Operation, precision models.Precision, args []string) (string, error) {
+	values := make([]*big.Int, len(args))
+	for i, arg := range args {
+		value, ok := parseInt(arg, precision)
+		if !ok {
+			return "", models.NewTaskError(models.ErrorCodeInvalidArguments, "invalid integer argument %q", arg)
+		}
+		values[i] = value
+	}
+
+	result, err := op.ExecuteInt(values)
+	if err != nil {
+		return "", err
+	}
+	if precision.Mode == models.PrecisionInteger && !result.IsInt64() {
+		return "", models.NewTaskError(models.ErrorCodeOverflow, "result of %s overflows int64", op.Type)
+	}
+	return result.String(), nil
+}
+
+// floorDivInt returns the quotient of a and b rounded down and the matching
+// remainder, which has the sign of b.
+func floorDivInt(a, b *big.Int) (*big.Int, *big.Int, error) {
+	if b.Sign() == 0 {
+		return nil, nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+	}
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	if r.Sign() != 0 && r.Sign() != b.Sign() {
+		q.Sub(q, big.NewInt(1))
+		r.Add(r, b)
+	}
+	return q, r, nil
+}
+
+// divideInt is / on integers, which must divide exactly.
+func divideInt(args []*big.Int) (*big.Int, error) {
+	q, r, err := floorDivInt(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if r.Sign() != 0 {
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "%s / %s is not an integer, use // for integer division", args[0], args[1])
+	}
+	return q, nil
+}
+
+// powerInt raises an integer to a non-negative integer power.
+func powerInt(args []*big.Int) (*big.Int, error) {
+	base, exponent := args[0], args[1]
+	if exponent.Sign() < 0 {
+		if base.Sign() == 0 {
+			return nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "zero raised to negative power %s", exponent)
+		}
+		return nil, models.NewTaskError(models.ErrorCodeDomain, "negative power %s has no integer result", exponent)
+	}
+
+	// Powers of 0, 1 and -1 stay small whatever the exponent.
+	if base.CmpAbs(big.NewInt(1)) <= 0 {
+		if base.Sign() < 0 && exponent.Bit(0) == 0 {
+			return big.NewInt(1), nil
+		}
+		if base.Sign() == 0 && exponent.Sign() == 0 {
+			return big.NewInt(1), nil
+		}
+		return new(big.Int).Set(base), nil
+	}
+
+	// The result has at least exponent·(bits of base - 1) bits. Dividing
+	// rather than multiplying keeps the bound from overflowing int64.
+	if !exponent.IsInt64() || exponent.Int64() > maxExactBits/int64(base.BitLen()-1) {
+		return nil, models.NewTaskError(models.ErrorCodeOverflow, "integer power %s^%s is too large", base, exponent)
+	}
+	return new(big.Int).Exp(base, exponent, nil), nil
+}
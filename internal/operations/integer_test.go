@@ -0,0 +1,77 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+func TestExecuteInt(t *testing.T) {
+	integer := models.Precision{Mode: models.PrecisionInteger}
+	bigint := models.Precision{Mode: models.PrecisionBigInt}
+
+	runBigTests(t, []bigTest{
+		{"add", integer, models.OperationAddition, []string{"2", "3"}, "5", ""},
+		{"add overflow", integer, models.OperationAddition, []string{"9223372036854775807", "1"}, "", models.ErrorCodeOverflow},
+		{"subtract overflow", integer, models.OperationSubtraction, []string{"-9223372036854775808", "1"}, "", models.ErrorCodeOverflow},
+		{"multiply overflow", integer, models.OperationMultiplication, []string{"4294967296", "4294967296"}, "", models.ErrorCodeOverflow},
+		{"negate overflow", integer, models.OperationNegation, []string{"-9223372036854775808"}, "", models.ErrorCodeOverflow},
+		{"exact divide", integer, models.OperationDivision, []string{"6", "3"}, "2", ""},
+		{"inexact divide", integer, models.OperationDivision, []string{"7", "2"}, "", models.ErrorCodeDomain},
+		{"divide by zero", integer, models.OperationDivision, []string{"7", "0"}, "", models.ErrorCodeDivisionByZero},
+		// Floor division and modulo round toward negative infinity.
+		{"floor divide", integer, models.OperationFloorDivision, []string{"-7", "2"}, "-4", ""},
+		{"modulo", integer, models.OperationModulo, []string{"-7", "2"}, "1", ""},
+		{"modulo negative divisor", integer, models.OperationModulo, []string{"7", "-2"}, "-1", ""},
+		{"fractional argument", integer, models.OperationAddition, []string{"1.5", "1"}, "", models.ErrorCodeInvalidArguments},
+		{"bigint add", bigint, models.OperationAddition, []string{"9223372036854775807", "1"}, "9223372036854775808", ""},
+		{"bigint multiply", bigint, models.OperationMultiplication, []string{"4294967296", "4294967296"}, "18446744073709551616", ""},
+		{"bigint negate", bigint, models.OperationNegation, []string{"-9223372036854775808"}, "9223372036854775808", ""},
+		{"bigint floor divide", bigint, models.OperationFloorDivision, []string{"-18446744073709551617", "2"}, "-9223372036854775809", ""},
+	})
+}
+
+func TestPowerIntBounds(t *testing.T) {
+	bigint := models.Precision{Mode: models.PrecisionBigInt}
+	integer := models.Precision{Mode: models.PrecisionInteger}
+
+	tests := []struct {
+		name      string
+		precision models.Precision
+		base      string
+		exponent  string
+		want      string
+		code      models.TaskErrorCode
+	}{
+		{"small", bigint, "2", "10", "1024", ""},
+		{"negative base odd", bigint, "-3", "3", "-27", ""},
+		{"zero to zero", bigint, "0", "0", "1", ""},
+		{"zero to huge", bigint, "0", "9223372036854775807", "0", ""},
+		{"one to huge", bigint, "1", "9223372036854775807", "1", ""},
+		{"minus one to huge odd", bigint, "-1", "9223372036854775807", "-1", ""},
+		{"minus one to huge even", bigint, "-1", "9223372036854775806", "1", ""},
+		{"at the bound", bigint, "2", "1048576", "", ""},
+		{"past the bound", bigint, "2", "1048577", "", models.ErrorCodeOverflow},
+		// 4·2^62 wraps int64 to 0 in exponent·(bits-1).
+		{"product wraps to zero", bigint, "16", "4611686018427387904", "", models.ErrorCodeOverflow},
+		{"product wraps negative", bigint, "16", "6917529027641081856", "", models.ErrorCodeOverflow},
+		{"max int64 exponent", bigint, "3", "9223372036854775807", "", models.ErrorCodeOverflow},
+		{"beyond int64 exponent", bigint, "2", "9223372036854775808", "", models.ErrorCodeOverflow},
+		{"negative exponent", bigint, "2", "-1", "", models.ErrorCodeDomain},
+		{"zero to negative", bigint, "0", "-1", "", models.ErrorCodeDivisionByZero},
+		{"int64 overflow", integer, "2", "63", "", models.ErrorCodeOverflow},
+		{"int64 fits", integer, "2", "62", "4611686018427387904", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExecuteBig(models.OperationPower, tt.precision, []string{tt.base, tt.exponent})
+			if code := taskErrorCode(t, err); code != tt.code {
+				t.Fatalf("%s ^ %s: error code %q (%v), want %q", tt.base, tt.exponent, code, err, tt.code)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("%s ^ %s = %s, want %s", tt.base, tt.exponent, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Package operations is the registry of operations a task can carry. It is
+// shared by the orchestrator and the agents so that both sides execute
+// operations and report failures identically.
+package operations
+
+import (
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+type Operation struct {
+	Type models.OperationType
+	// Function is the name the operation is called by in expressions; it is
+	// empty for operators.
+	Function string
+	MinArgs  int
+	// MaxArgs is the maximum number of arguments, or -1 if unbounded.
+	MaxArgs int
+	// DefaultTime is the simulated cost in milliseconds of a function,
+	// overridable with TIME_<FUNCTION>_MS.
+	DefaultTime int
+	Execute     func(args []float64) (float64, error)
+	// ExecuteRat evaluates the operation exactly, for the rational and
+	// decimal precisions. It is nil for operations with irrational results.
+	ExecuteRat func(args []*big.Rat) (*big.Rat, error)
+	// ExecuteFloat evaluates the operation for the bigfloat precision,
+	// storing the result in z, which carries the mantissa size and rounding
+	// to round it with. It is nil for operations math/big cannot compute.
+	ExecuteFloat func(z *big.Float, args []*big.Float) (*big.Float, error)
+	// ExecuteInt evaluates the operation on whole numbers, for the integer
+	// and bigint precisions. It is nil for operations with fractional
+	// results.
+	ExecuteInt func(args []*big.Int) (*big.Int, error)
+}
+
+var registry = map[models.OperationType]*Operation{}
+
+var functions = map[string]*Operation{}
+
+func register(op *Operation) {
+	registry[op.Type] = op
+	if op.Function != "" {
+		functions[op.Function] = op
+	}
+}
+
+// Lookup returns the operation of the given type.
+func Lookup(opType models.OperationType) (*Operation, bool) {
+	op, ok := registry[opType]
+	return op, ok
+}
+
+// LookupFunction returns the operation called by name in expressions.
+func LookupFunction(name string) (*Operation, bool) {
+	op, ok := functions[name]
+	return op, ok
+}
+
+// Functions returns every callable function, ordered by name.
+func Functions() []*Operation {
+	result := make([]*Operation, 0, len(functions))
+	for _, op := range functions {
+		result = append(result, op)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Function < result[j].Function
+	})
+	return result
+}
+
+// Types returns the types of every registered operation, ordered by name.
+func Types() []models.OperationType {
+	result := make([]models.OperationType, 0, len(registry))
+	for opType := range registry {
+		result = append(result, opType)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// AcceptsArgs reports whether the operation can be called with n arguments.
+func (o *Operation) AcceptsArgs(n int) bool {
+	return n >= o.MinArgs && (o.MaxArgs < 0 || n <= o.MaxArgs)
+}
+
+// Execute runs an operation on its arguments. Failures are returned as
+// *models.TaskError.
+func Execute(opType models.OperationType, args []float64) (float64, error) {
+	op, ok := registry[opType]
+	if !ok {
+		return 0, models.NewTaskError(models.ErrorCodeUnknownOperation, "unknown operation type: %s", opType)
+	}
+	if !op.AcceptsArgs(len(args)) {
+		return 0, models.NewTaskError(models.ErrorCodeInvalidArguments, "operation %s does not accept %d arguments", opType, len(args))
+	}
+	return op.Execute(args)
+}
+
+func init() {
+	register(&Operation{Type: models.OperationValue, MinArgs: 1, MaxArgs: 1, Execute: func(args []float64) (float64, error) {
+		return args[0], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return args[0], nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Set(args[0]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return args[0], nil
+	}})
+	register(&Operation{Type: models.OperationAddition, MinArgs: 2, MaxArgs: 2, Execute: func(args []float64) (float64, error) {
+		return args[0] + args[1], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Add(args[0], args[1]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Add(args[0], args[1]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Add(args[0], args[1]), nil
+	}})
+	register(&Operation{Type: models.OperationSubtraction, MinArgs: 2, MaxArgs: 2, Execute: func(args []float64) (float64, error) {
+		return args[0] - args[1], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Sub(args[0], args[1]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Sub(args[0], args[1]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Sub(args[0], args[1]), nil
+	}})
+	register(&Operation{Type: models.OperationMultiplication, MinArgs: 2, MaxArgs: 2, Execute: func(args []float64) (float64, error) {
+		return args[0] * args[1], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Mul(args[0], args[1]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Mul(args[0], args[1]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Mul(args[0], args[1]), nil
+	}})
+	register(&Operation{Type: models.OperationDivision, MinArgs: 2, MaxArgs: 2, Execute: func(args []float64) (float64, error) {
+		if args[1] == 0 {
+			return 0, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+		}
+		return args[0] / args[1], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		if args[1].Sign() == 0 {
+			return nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+		}
+		return new(big.Rat).Quo(args[0], args[1]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		if args[1].Sign() == 0 {
+			return nil, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+		}
+		return z.Quo(args[0], args[1]), nil
+	}, ExecuteInt: divideInt})
+	register(&Operation{Type: models.OperationFloorDivision, MinArgs: 2, MaxArgs: 2, Execute: func(args []float64) (float64, error) {
+		if args[1] == 0 {
+			return 0, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+		}
+		return math.Floor(args[0] / args[1]), nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		q, _, err := floorDivRat(args[0], args[1])
+		return q, err
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		q, _, err := floorDivFloat(z, args[0], args[1])
+		return q, err
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		q, _, err := floorDivInt(args[0], args[1])
+		return q, err
+	}})
+	register(&Operation{Type: models.OperationModulo, MinArgs: 2, MaxArgs: 2, Execute: modulo, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		_, r, err := floorDivRat(args[0], args[1])
+		return r, err
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		_, r, err := floorDivFloat(z, args[0], args[1])
+		return r, err
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		_, r, err := floorDivInt(args[0], args[1])
+		return r, err
+	}})
+	register(&Operation{Type: models.OperationNegation, MinArgs: 1, MaxArgs: 1, Execute: func(args []float64) (float64, error) {
+		return -args[0], nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Neg(args[0]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Neg(args[0]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Neg(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationPower, MinArgs: 2, MaxArgs: 2, Execute: power, ExecuteRat: powerRat, ExecuteFloat: powerFloat, ExecuteInt: powerInt})
+
+	register(&Operation{Type: models.OperationSqrt, Function: "sqrt", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, models.NewTaskError(models.ErrorCodeDomain, "square root of negative number %g", args[0])
+		}
+		return math.Sqrt(args[0]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		if args[0].Sign() < 0 {
+			return nil, models.NewTaskError(models.ErrorCodeDomain, "square root of negative number %s", formatFloat(args[0]))
+		}
+		return z.Sqrt(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationAbs, Function: "abs", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		return math.Abs(args[0]), nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Abs(args[0]), nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		return z.Abs(args[0]), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Abs(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationLn, Function: "ln", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, models.NewTaskError(models.ErrorCodeDomain, "logarithm of non-positive number %g", args[0])
+		}
+		return math.Log(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationLog, Function: "log", MinArgs: 1, MaxArgs: 2, DefaultTime: 1000, Execute: logarithm})
+	register(&Operation{Type: models.OperationSin, Function: "sin", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		return math.Sin(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationCos, Function: "cos", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		return math.Cos(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationTan, Function: "tan", MinArgs: 1, MaxArgs: 1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		return math.Tan(args[0]), nil
+	}})
+	register(&Operation{Type: models.OperationMin, Function: "min", MinArgs: 1, MaxArgs: -1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
+		}
+		return z.Set(result), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}})
+	register(&Operation{Type: models.OperationMax, Function: "max", MinArgs: 1, MaxArgs: -1, DefaultTime: 1000, Execute: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}, ExecuteRat: func(args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}, ExecuteFloat: func(z *big.Float, args []*big.Float) (*big.Float, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return z.Set(result), nil
+	}, ExecuteInt: func(args []*big.Int) (*big.Int, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}})
+	register(&Operation{Type: models.OperationRound, Function: "round", MinArgs: 1, MaxArgs: 2, DefaultTime: 1000, Execute: round, ExecuteRat: roundRat, ExecuteFloat: roundFloat})
+}
+
+func power(args []float64) (float64, error) {
+	base, exponent := args[0], args[1]
+	if base == 0 && exponent < 0 {
+		return 0, models.NewTaskError(models.ErrorCodeDivisionByZero, "zero raised to negative power %g", exponent)
+	}
+	if base < 0 && exponent != math.Trunc(exponent) {
+		return 0, models.NewTaskError(models.ErrorCodeDomain, "negative base %g with fractional exponent %g has no real result", base, exponent)
+	}
+	return math.Pow(base, exponent), nil
+}
+
+// modulo computes a % b, the remainder of floor division, which has the sign
+// of b.
+func modulo(args []float64) (float64, error) {
+	if args[1] == 0 {
+		return 0, models.NewTaskError(models.ErrorCodeDivisionByZero, "division by zero")
+	}
+	r := math.Mod(args[0], args[1])
+	if r != 0 && (r < 0) != (args[1] < 0) {
+		r += args[1]
+	}
+	return r, nil
+}
+
+// logarithm computes log(x, base), with base 10 when omitted.
+func logarithm(args []float64) (float64, error) {
+	x, base := args[0], 10.0
+	if len(args) == 2 {
+		base = args[1]
+	}
+	if x <= 0 {
+		return 0, models.NewTaskError(models.ErrorCodeDomain, "logarithm of non-positive number %g", x)
+	}
+	if base <= 0 || base == 1 {
+		return 0, models.NewTaskError(models.ErrorCodeDomain, "invalid logarithm base %g", base)
+	}
+	return math.Log(x) / math.Log(base), nil
+}
+
+// round rounds x half away from zero to the given number of decimal digits,
+// 0 when omitted. Negative digits round to tens, hundreds and so on.
+func round(args []float64) (float64, error) {
+	x, digits := args[0], 0.0
+	if len(args) == 2 {
+		digits = args[1]
+	}
+	if digits != math.Trunc(digits) {
+		return 0, models.NewTaskError(models.ErrorCodeDomain, "round digits must be an integer, got %g", digits)
+	}
+	if digits < -maxRoundDigits || digits > maxRoundDigits {
+		return 0, models.NewTaskError(models.ErrorCodeDomain, "round digits must be between %d and %d", -maxRoundDigits, maxRoundDigits)
+	}
+
+	scale := math.Pow(10, digits)
+	if scale == 0 {
+		// Every float64 is below half of 10^-digits.
+		return math.Copysign(0, x), nil
+	}
+	scaled := x * scale
+	if math.IsInf(scale, 1) || math.IsInf(scaled, 0) {
+		// The digits are finer than x can hold.
+		return x, nil
+	}
+	return math.Round(scaled) / scale, nil
+}
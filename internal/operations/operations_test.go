@@ -0,0 +1,106 @@
+package operations
+
+import (
+	"math"
+	"testing"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		function string
+		op       models.OperationType
+		min, max int
+	}{
+		{"abs", models.OperationAbs, 1, 1},
+		{"cos", models.OperationCos, 1, 1},
+		{"ln", models.OperationLn, 1, 1},
+		{"log", models.OperationLog, 1, 2},
+		{"max", models.OperationMax, 1, -1},
+		{"min", models.OperationMin, 1, -1},
+		{"round", models.OperationRound, 1, 2},
+		{"sin", models.OperationSin, 1, 1},
+		{"sqrt", models.OperationSqrt, 1, 1},
+		{"tan", models.OperationTan, 1, 1},
+	}
+
+	functions := Functions()
+	if len(functions) != len(tests) {
+		t.Errorf("%d functions registered, want %d", len(functions), len(tests))
+	}
+	for i, tt := range tests {
+		op, ok := LookupFunction(tt.function)
+		if !ok {
+			t.Errorf("function %s not registered", tt.function)
+			continue
+		}
+		if i < len(functions) && functions[i] != op {
+			t.Errorf("function %d is %s, want %s", i, functions[i].Function, tt.function)
+		}
+		if byType, _ := Lookup(tt.op); op.Type != tt.op || byType != op {
+			t.Errorf("function %s has type %s, want %s", tt.function, op.Type, tt.op)
+		}
+		if op.AcceptsArgs(tt.min-1) || !op.AcceptsArgs(tt.min) {
+			t.Errorf("%s: accepts %d arguments: %t, %d: %t; want at least %d", tt.function,
+				tt.min-1, op.AcceptsArgs(tt.min-1), tt.min, op.AcceptsArgs(tt.min), tt.min)
+		}
+		if tt.max >= 0 && (!op.AcceptsArgs(tt.max) || op.AcceptsArgs(tt.max+1)) {
+			t.Errorf("%s: want at most %d arguments", tt.function, tt.max)
+		}
+		if tt.max < 0 && !op.AcceptsArgs(100) {
+			t.Errorf("%s: want any number of arguments", tt.function)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		op   models.OperationType
+		args []float64
+		want float64
+		code models.TaskErrorCode
+	}{
+		{"sqrt", models.OperationSqrt, []float64{9}, 3, ""},
+		{"sqrt of negative", models.OperationSqrt, []float64{-1}, 0, models.ErrorCodeDomain},
+		{"abs", models.OperationAbs, []float64{-2.5}, 2.5, ""},
+		{"ln", models.OperationLn, []float64{math.E}, 1, ""},
+		{"ln of zero", models.OperationLn, []float64{0}, 0, models.ErrorCodeDomain},
+		{"ln of negative", models.OperationLn, []float64{-1}, 0, models.ErrorCodeDomain},
+		{"log", models.OperationLog, []float64{1000}, 3, ""},
+		{"log base", models.OperationLog, []float64{8, 2}, 3, ""},
+		{"log of negative", models.OperationLog, []float64{-8}, 0, models.ErrorCodeDomain},
+		{"log base one", models.OperationLog, []float64{8, 1}, 0, models.ErrorCodeDomain},
+		{"log negative base", models.OperationLog, []float64{8, -2}, 0, models.ErrorCodeDomain},
+		{"min", models.OperationMin, []float64{3, -1, 2}, -1, ""},
+		{"max", models.OperationMax, []float64{3, -1, 2}, 3, ""},
+		{"round", models.OperationRound, []float64{2.5}, 3, ""},
+		{"round negative", models.OperationRound, []float64{-2.5}, -3, ""},
+		{"round digits", models.OperationRound, []float64{3.14159, 2}, 3.14, ""},
+		{"round tens", models.OperationRound, []float64{1250, -2}, 1300, ""},
+		{"round fractional digits", models.OperationRound, []float64{1, 0.5}, 0, models.ErrorCodeDomain},
+		// Digits beyond float64 range leave x as it is or round it to 0.
+		{"round past precision", models.OperationRound, []float64{1.5, 400}, 1.5, ""},
+		{"round zero past precision", models.OperationRound, []float64{0, 400}, 0, ""},
+		{"round large past precision", models.OperationRound, []float64{1e300, 100}, 1e300, ""},
+		{"round past range", models.OperationRound, []float64{1e300, -400}, 0, ""},
+		{"round digits bound", models.OperationRound, []float64{1, 1001}, 0, models.ErrorCodeDomain},
+		{"round negative digits bound", models.OperationRound, []float64{1, -1001}, 0, models.ErrorCodeDomain},
+		{"too few arguments", models.OperationSqrt, nil, 0, models.ErrorCodeInvalidArguments},
+		{"too many arguments", models.OperationLog, []float64{1, 2, 3}, 0, models.ErrorCodeInvalidArguments},
+		{"unknown operation", models.OperationType("CBRT"), []float64{8}, 0, models.ErrorCodeUnknownOperation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Execute(tt.op, tt.args)
+			if code := taskErrorCode(t, err); code != tt.code {
+				t.Fatalf("%s %v: error code %q (%v), want %q", tt.op, tt.args, code, err, tt.code)
+			}
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-12*math.Max(1, math.Abs(tt.want)) {
+				t.Errorf("%s %v = %g, want %g", tt.op, tt.args, got, tt.want)
+			}
+		})
+	}
+}
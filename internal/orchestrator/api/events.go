@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment, so that
+// proxies do not time it out.
+const eventKeepAlive = 15 * time.Second
+
+// ExpressionEventsHandler streams the changes to one expression and its
+// tasks as Server-Sent Events. The stream starts with the current state of
+// the expression and ends once it has finished.
+func (h *Handler) ExpressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid expression ID")
+		return
+	}
+
+	// Subscribe before reading the current state so that no change falls
+	// in between.
+	events, cancel := h.service.SubscribeEvents(models.EventFilter{ExpressionID: id})
+	defer cancel()
+
+	current, err := h.service.ExpressionEvent(id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Expression not found")
+		return
+	}
+	streamEvents(w, r, events, &current, true)
+}
+
+// EventsHandler streams the changes to all expressions and their tasks as
+// Server-Sent Events, optionally only those of expressions in the statuses
+// given by ?status=.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, ok := parseStatuses(w, r)
+	if !ok {
+		return
+	}
+
+	events, cancel := h.service.SubscribeEvents(models.EventFilter{Statuses: statuses})
+	defer cancel()
+	streamEvents(w, r, events, nil, false)
+}
+
+// streamEvents writes initial, if set, and then the events received until
+// the client goes away or the subscription ends, which the client sees as
+// the end of the stream. With untilFinal the stream also ends after an
+// expression event with a final status.
+func streamEvents(w http.ResponseWriter, r *http.Request, events <-chan models.Event, initial *models.Event, untilFinal bool) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event models.Event) bool {
+		if err := writeEvent(w, event); err != nil {
+			log.Printf("Failed to write event: %v", err)
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	finished := func(event models.Event) bool {
+		return untilFinal && event.Type == models.EventExpression && event.Status.IsFinal()
+	}
+
+	if initial != nil && (!send(*initial) || finished(*initial)) {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || !send(event) || finished(event) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes an event as a Server-Sent Events message named after
+// its type. Numbered events carry their number as the message ID.
+func writeEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// readEvents reads a Server-Sent Events stream until it ends and returns
+// its events.
+func readEvents(t *testing.T, resp *http.Response) []models.Event {
+	t.Helper()
+	var events []models.Event
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event models.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// TestExpressionEventsEnd follows an expression while it is computed: the
+// stream reports each change and ends once the expression has finished.
+func TestExpressionEventsEnd(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+	id := submitExpression(t, server, "1 + 2")
+
+	resp, err := http.Get(server.URL + "/api/v1/expressions/" + id.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	streamed := make(chan []models.Event, 1)
+	go func() { streamed <- readEvents(t, resp) }()
+
+	task, err := svc.GetNextTask(t.Context(), uuid.Nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SubmitTaskResult(models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []models.Event
+	select {
+	case events = <-streamed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after the expression finished")
+	}
+	if len(events) < 2 || events[0].Type != models.EventExpression || events[0].Status.IsFinal() {
+		t.Fatalf("streamed %+v, want the unfinished expression first", events)
+	}
+	last := events[len(events)-1]
+	if last.Type != models.EventExpression || last.Status != models.StatusCompleted || last.Result == nil || *last.Result != 3 {
+		t.Errorf("last event is %s %s with result %v, want the expression COMPLETED with 3", last.Type, last.Status, last.Result)
+	}
+	var sawTask bool
+	for _, event := range events {
+		sawTask = sawTask || event.Type == models.EventTask && event.Task != nil && event.Task.ID == task.ID
+	}
+	if !sawTask {
+		t.Errorf("no event for task %s in %+v", task.ID, events)
+	}
+}
+
+// TestFinishedExpressionEvents follows an expression that has already
+// finished: the stream holds its state only.
+func TestFinishedExpressionEvents(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+	id := submitExpression(t, server, "1 + 2")
+	if _, err := svc.CancelExpression(id); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/expressions/" + id.String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp)
+	if len(events) != 1 || events[0].Status != models.StatusCancelled {
+		t.Errorf("streamed %+v, want the CANCELLED state only", events)
+	}
+
+	if code := doJSON(t, http.MethodGet, server.URL+"/api/v1/expressions/"+uuid.NewString()+"/events", nil, nil); code != http.StatusNotFound {
+		t.Errorf("unknown expression: status %d, want %d", code, http.StatusNotFound)
+	}
+}
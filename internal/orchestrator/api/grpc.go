@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
+	"github.com/popvictor123/distributed-calc/internal/taskpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxAgentCapacity bounds the capacity an agent may announce.
+const maxAgentCapacity = 1000
+
+// TaskServer serves the streaming gRPC protocol, the push-based counterpart
+// of the /internal HTTP endpoints.
+type TaskServer struct {
+	taskpb.UnimplementedTaskServiceServer
+	service *service.Service
+}
+
+func NewTaskServer(service *service.Service) *TaskServer {
+	return &TaskServer{
+		service: service,
+	}
+}
+
+// Work pushes ready tasks to the agent while it has free capacity and
+// acknowledges the results it streams back. Each task pushed takes one of
+// the agent's slots until its lease ends.
+func (s *TaskServer) Work(stream taskpb.TaskService_WorkServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	hello := first.GetHello()
+	if hello == nil {
+		return status.Error(codes.InvalidArgument, "first message must be hello")
+	}
+	capacity := int(hello.GetCapacity())
+	if capacity < 1 || capacity > maxAgentCapacity {
+		return status.Errorf(codes.InvalidArgument, "capacity must be between 1 and %d", maxAgentCapacity)
+	}
+	agentID := uuid.Nil
+	if hello.GetAgentId() != "" {
+		if agentID, err = uuid.Parse(hello.GetAgentId()); err != nil {
+			return status.Error(codes.InvalidArgument, "invalid agent ID")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	leases := newSessionLeases(capacity)
+
+	// Send is called from both goroutines below and must not run
+	// concurrently.
+	var sendMutex sync.Mutex
+	send := func(msg *taskpb.OrchestratorMessage) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		return stream.Send(msg)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.pushTasks(ctx, agentID, send, leases) }()
+	go func() { errs <- s.receiveResults(stream, send, leases) }()
+
+	err = <-errs
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// pushTasks leases as many tasks as the agent has free slots and sends them.
+// Tasks lost with a broken stream are re-queued when their leases expire.
+func (s *TaskServer) pushTasks(ctx context.Context, agentID uuid.UUID, send func(*taskpb.OrchestratorMessage) error, leases *sessionLeases) error {
+	revoked := s.service.LeasesRevoked()
+	for {
+		select {
+		case <-revoked:
+			revoked = s.service.LeasesRevoked()
+			leases.dropRevoked(s.service)
+		default:
+		}
+
+		slots := leases.free()
+		if slots == 0 {
+			select {
+			case <-leases.ended:
+			case <-revoked:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		tasks, err := s.service.GetNextTasks(ctx, agentID, slots, maxTaskWait)
+		if errors.Is(err, service.ErrAgentDraining) {
+			// Keep the stream open so the agent can still send its results.
+			msg := &taskpb.OrchestratorMessage{Message: &taskpb.OrchestratorMessage_Drain{Drain: &taskpb.Drain{}}}
+			if err := send(msg); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		if errors.Is(err, service.ErrShuttingDown) {
+			// The agent reconnects once the orchestrator is back.
+			return status.Error(codes.Unavailable, "orchestrator is shutting down")
+		}
+		if err != nil && !errors.Is(err, repository.ErrNoTasksAvailable) {
+			return err
+		}
+
+		for _, task := range tasks {
+			leases.add(task.ID, task.LeaseToken)
+			msg := &taskpb.OrchestratorMessage{
+				Message: &taskpb.OrchestratorMessage_Task{Task: taskpb.NewTask(newTaskResponse(task))},
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receiveResults submits the results the agent streams back and
+// acknowledges each. A result that cannot be recorded, because it is
+// malformed or fails to save, gives its task back to the ready queue so that
+// another agent computes it.
+func (s *TaskServer) receiveResults(stream taskpb.TaskService_WorkServer, send func(*taskpb.OrchestratorMessage) error, leases *sessionLeases) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		result := msg.GetResult()
+		if result == nil {
+			return status.Error(codes.InvalidArgument, "expected a task result")
+		}
+
+		var item models.TaskResultItemResponse
+		req, err := result.ToModel()
+		if err != nil {
+			id, _ := uuid.Parse(result.GetId())
+			item = models.TaskResultItemResponse{ID: id, Status: models.SubmitStatusError, Error: fmt.Sprintf("invalid task result: %v", err)}
+		} else {
+			item = submitResult(s.service, req)
+		}
+
+		// Results for tasks leased on an earlier stream hold no slot of this
+		// one.
+		if leaseToken, held := leases.end(item.ID); held && item.Status == models.SubmitStatusError {
+			if _, err := s.service.ReleaseTasks([]models.TaskLease{{ID: item.ID, LeaseToken: leaseToken}}); err != nil {
+				log.Printf("Failed to release task %s: %v", item.ID, err)
+			}
+		}
+
+		ack := &taskpb.OrchestratorMessage{
+			Message: &taskpb.OrchestratorMessage_Ack{Ack: taskpb.NewTaskResultAck(item)},
+		}
+		if err := send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// sessionLeases tracks the tasks pushed on one stream, each of which takes
+// one of the agent's slots until its lease ends: with a result, or when the
+// lease is released or re-queued.
+type sessionLeases struct {
+	mutex    sync.Mutex
+	capacity int
+	// tokens maps each task leased on the stream to its lease token.
+	tokens map[uuid.UUID]uuid.UUID
+	// ended receives a value whenever a result frees a slot.
+	ended chan struct{}
+}
+
+func newSessionLeases(capacity int) *sessionLeases {
+	return &sessionLeases{
+		capacity: capacity,
+		tokens:   make(map[uuid.UUID]uuid.UUID),
+		ended:    make(chan struct{}, 1),
+	}
+}
+
+// free returns the number of slots not taken by a lease.
+func (l *sessionLeases) free() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.capacity - len(l.tokens)
+}
+
+func (l *sessionLeases) add(taskID, leaseToken uuid.UUID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.tokens[taskID] = leaseToken
+}
+
+// end frees the slot of a task and returns its lease token, reporting
+// whether the task was leased on this stream.
+func (l *sessionLeases) end(taskID uuid.UUID) (uuid.UUID, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	leaseToken, held := l.tokens[taskID]
+	if !held {
+		return uuid.Nil, false
+	}
+	delete(l.tokens, taskID)
+	select {
+	case l.ended <- struct{}{}:
+	default:
+	}
+	return leaseToken, true
+}
+
+// dropRevoked frees the slots of the tasks whose leases were released or
+// re-queued.
+func (l *sessionLeases) dropRevoked(svc *service.Service) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for taskID, leaseToken := range l.tokens {
+		if !svc.LeaseHeld(taskID, leaseToken) {
+			delete(l.tokens, taskID)
+		}
+	}
+}
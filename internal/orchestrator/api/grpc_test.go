@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
+	"github.com/popvictor123/distributed-calc/internal/taskpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startTaskServer serves the gRPC protocol over an in-memory listener and
+// returns a client for it.
+func startTaskServer(t *testing.T, svc *service.Service) taskpb.TaskServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(server, NewTaskServer(svc))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	dial := func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return taskpb.NewTaskServiceClient(conn)
+}
+
+func newTestService(t *testing.T) *service.Service {
+	t.Helper()
+	t.Setenv("TIME_ADDITION_MS", "0")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "0")
+	svc := service.NewService(repository.NewRepository(), calculator.NewCalculator())
+	t.Cleanup(svc.Shutdown)
+	return svc
+}
+
+// openSession starts a Work stream and announces capacity for agentID.
+func openSession(t *testing.T, client taskpb.TaskServiceClient, agentID uuid.UUID, capacity int32) (taskpb.TaskService_WorkClient, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	stream, err := client.Work(ctx)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	hello := &taskpb.AgentMessage{
+		Message: &taskpb.AgentMessage_Hello{Hello: &taskpb.Hello{Capacity: capacity, AgentId: agentID.String()}},
+	}
+	if err := stream.Send(hello); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	return stream, cancel
+}
+
+func receiveTask(t *testing.T, stream taskpb.TaskService_WorkClient) *models.TaskResponse {
+	t.Helper()
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetTask() == nil {
+		t.Fatalf("received %v, want a task", msg)
+	}
+	task, err := msg.GetTask().ToModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func sendResult(t *testing.T, stream taskpb.TaskService_WorkClient, result *taskpb.TaskResult) {
+	t.Helper()
+	msg := &taskpb.AgentMessage{Message: &taskpb.AgentMessage_Result{Result: result}}
+	if err := stream.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveAck(t *testing.T, stream taskpb.TaskService_WorkClient, id uuid.UUID, status string) {
+	t.Helper()
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := msg.GetAck()
+	if ack == nil || ack.GetId() != id.String() || ack.GetStatus() != status {
+		t.Fatalf("received %v, want %s acknowledgement for %s", msg, status, id)
+	}
+}
+
+func calculate(t *testing.T, svc *service.Service, expression string) uuid.UUID {
+	t.Helper()
+	expr, err := svc.CalculateExpression(models.CalculateRequest{Expression: expression})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr.ID
+}
+
+func expectResult(t *testing.T, svc *service.Service, id uuid.UUID, want models.Float) {
+	t.Helper()
+	expr, err := svc.GetExpressionByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.Status != models.StatusCompleted || expr.Result == nil || *expr.Result != want {
+		t.Errorf("expression %s is %s with result %v, want COMPLETED %v", id, expr.Status, expr.Result, want)
+	}
+}
+
+// TestWorkReconnect pushes a task, acknowledges its result, then breaks the
+// stream with another task in flight. Its result, sent again on the next
+// stream, is accepted without taking a slot of the new one.
+func TestWorkReconnect(t *testing.T) {
+	svc := newTestService(t)
+	client := startTaskServer(t, svc)
+	agentID := svc.RegisterAgent(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1}).ID
+
+	first := calculate(t, svc, "1 + 2")
+	stream, cancel := openSession(t, client, agentID, 1)
+	task := receiveTask(t, stream)
+	sendResult(t, stream, taskpb.NewTaskResult(models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken, Result: 3}))
+	receiveAck(t, stream, task.ID, models.SubmitStatusSuccess)
+	expectResult(t, svc, first, 3)
+
+	second := calculate(t, svc, "2 * 3")
+	inFlight := receiveTask(t, stream)
+	cancel()
+
+	third := calculate(t, svc, "4 + 5")
+	stream, cancel = openSession(t, client, agentID, 1)
+	defer cancel()
+	task = receiveTask(t, stream)
+	if task.ID == inFlight.ID {
+		t.Fatalf("task %s pushed again while its lease is held", task.ID)
+	}
+	sendResult(t, stream, taskpb.NewTaskResult(models.TaskResultRequest{ID: inFlight.ID, LeaseToken: inFlight.LeaseToken, Result: 6}))
+	receiveAck(t, stream, inFlight.ID, models.SubmitStatusSuccess)
+	sendResult(t, stream, taskpb.NewTaskResult(models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken, Result: 9}))
+	receiveAck(t, stream, task.ID, models.SubmitStatusSuccess)
+
+	expectResult(t, svc, second, 6)
+	expectResult(t, svc, third, 9)
+}
+
+// TestWorkFreesRevokedSlots checks that a slot comes back when its lease
+// ends without a result: the task is pushed again on the same stream.
+func TestWorkFreesRevokedSlots(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, svc *service.Service, stream taskpb.TaskService_WorkClient, task *models.TaskResponse)
+	}{
+		{
+			name: "released",
+			revoke: func(t *testing.T, svc *service.Service, stream taskpb.TaskService_WorkClient, task *models.TaskResponse) {
+				released, err := svc.ReleaseTasks([]models.TaskLease{{ID: task.ID, LeaseToken: task.LeaseToken}})
+				if err != nil || released != 1 {
+					t.Fatalf("released %d tasks: %v", released, err)
+				}
+			},
+		},
+		{
+			name: "re-queued",
+			revoke: func(t *testing.T, svc *service.Service, stream taskpb.TaskService_WorkClient, task *models.TaskResponse) {
+				ctx, cancel := context.WithCancel(context.Background())
+				t.Cleanup(cancel)
+				go svc.RunLeaseReaper(ctx)
+			},
+		},
+		{
+			name: "malformed result",
+			revoke: func(t *testing.T, svc *service.Service, stream taskpb.TaskService_WorkClient, task *models.TaskResponse) {
+				sendResult(t, stream, &taskpb.TaskResult{Id: task.ID.String(), LeaseToken: "not a token", Result: 3})
+				receiveAck(t, stream, task.ID, models.SubmitStatusError)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Long enough to answer the task pushed again before the
+			// reaper takes it back too.
+			t.Setenv("LEASE_SLACK_MS", "300")
+			t.Setenv("LEASE_REAP_INTERVAL_MS", "1")
+			svc := newTestService(t)
+			client := startTaskServer(t, svc)
+			agentID := svc.RegisterAgent(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1}).ID
+
+			id := calculate(t, svc, "1 + 2")
+			stream, cancel := openSession(t, client, agentID, 1)
+			defer cancel()
+			task := receiveTask(t, stream)
+			tt.revoke(t, svc, stream, task)
+
+			again := receiveTask(t, stream)
+			if again.ID != task.ID || again.LeaseToken == task.LeaseToken {
+				t.Fatalf("pushed %s with token %s, want %s with a new lease", again.ID, again.LeaseToken, task.ID)
+			}
+			sendResult(t, stream, taskpb.NewTaskResult(models.TaskResultRequest{ID: again.ID, LeaseToken: again.LeaseToken, Result: 3}))
+			receiveAck(t, stream, again.ID, models.SubmitStatusSuccess)
+			expectResult(t, svc, id, 3)
+		})
+	}
+}
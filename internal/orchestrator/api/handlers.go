@@ -2,12 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
-	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
 )
 
 type Handler struct {
@@ -21,41 +28,134 @@ func NewHandler(service *service.Service) *Handler {
 }
 
 func (h *Handler) CalculateHandler(w http.ResponseWriter, r *http.Request) {
-	var req models.CalculateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondWithError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+	expr, ok := h.submitExpression(w, r)
+	if !ok {
 		return
 	}
 
-	if req.Expression == "" {
-		respondWithError(w, http.StatusUnprocessableEntity, "Expression is required")
+	resp := models.CalculateResponse{
+		ID: expr.ID,
+	}
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// defaultEvaluateTimeout and maxEvaluateTimeout bound how long
+// EvaluateHandler waits for an expression.
+const (
+	defaultEvaluateTimeout = 10 * time.Second
+	maxEvaluateTimeout     = 60 * time.Second
+)
+
+// EvaluateHandler submits an expression like CalculateHandler and waits up
+// to ?timeout= for it to finish. A finished expression is returned with 200
+// whatever its status; one still running when the timeout expires with 202
+// and its location, to be polled from there.
+func (h *Handler) EvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	timeout, ok := parseDuration(w, r, "timeout", defaultEvaluateTimeout, maxEvaluateTimeout)
+	if !ok {
+		return
+	}
+	expr, ok := h.submitExpression(w, r)
+	if !ok {
 		return
 	}
 
-	expr, err := h.service.CalculateExpression(req.Expression)
+	expr, err := h.service.WaitForExpression(r.Context(), expr.ID, timeout)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process expression")
+		respondWithError(w, http.StatusInternalServerError, "Failed to read expression")
 		return
 	}
 
-	resp := models.CalculateResponse{
-		ID: expr.ID,
+	response := models.ExpressionDetailResponse{
+		Expression: newExpressionResponse(expr),
+	}
+	if !expr.Status.IsFinal() {
+		w.Header().Set("Location", "/api/v1/expressions/"+expr.ID.String())
+		respondWithJSON(w, http.StatusAccepted, response)
+		return
 	}
+	respondWithJSON(w, http.StatusOK, response)
+}
 
-	respondWithJSON(w, http.StatusCreated, resp)
+// submitExpression decodes a calculation request and submits it, responding
+// with an error and returning false if it is rejected.
+func (h *Handler) submitExpression(w http.ResponseWriter, r *http.Request) (*models.Expression, bool) {
+	var req models.CalculateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+		return nil, false
+	}
+
+	if req.Expression == "" {
+		respondWithError(w, http.StatusUnprocessableEntity, "Expression is required")
+		return nil, false
+	}
+
+	expr, err := h.service.CalculateExpression(req)
+	if errors.Is(err, models.ErrInvalidPrecision) || errors.Is(err, models.ErrInvalidCallbackURL) ||
+		errors.Is(err, service.ErrWebhooksDisabled) {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, false
+	}
+	var parseErr *calculator.ParseError
+	if errors.As(err, &parseErr) {
+		respondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "Invalid expression",
+			"details": parseErr,
+		})
+		return nil, false
+	}
+	var unbound *calculator.UnboundVariablesError
+	if errors.As(err, &unbound) {
+		respondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "Unbound variables",
+			"missing": unbound.Names,
+		})
+		return nil, false
+	}
+	var unsupported *calculator.UnsupportedPrecisionError
+	if errors.As(err, &unsupported) {
+		respondWithError(w, http.StatusUnprocessableEntity, unsupported.Error())
+		return nil, false
+	}
+	var outOfRange *calculator.NumberRangeError
+	if errors.As(err, &outOfRange) {
+		respondWithError(w, http.StatusUnprocessableEntity, outOfRange.Error())
+		return nil, false
+	}
+	var nonInteger *calculator.NonIntegerError
+	if errors.As(err, &nonInteger) {
+		respondWithError(w, http.StatusUnprocessableEntity, nonInteger.Error())
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to process expression")
+		return nil, false
+	}
+	return expr, true
 }
 
 func (h *Handler) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	expressions := h.service.GetAllExpressions()
-	var response models.ExpressionsResponse
-	response.Expressions = make([]models.ExpressionResponse, 0, len(expressions))
-
-	for _, expr := range expressions {
-		response.Expressions = append(response.Expressions, models.ExpressionResponse{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
-		})
+	query, ok := parseExpressionQuery(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := h.service.ListExpressions(query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to list expressions")
+		return
+	}
+	response := models.ExpressionsResponse{
+		Expressions: make([]models.ExpressionResponse, 0, len(page.Expressions)),
+		Total:       page.Total,
+	}
+	for _, expr := range page.Expressions {
+		response.Expressions = append(response.Expressions, newExpressionResponse(expr))
+	}
+	if page.Next != nil {
+		response.NextCursor = page.Next.String()
 	}
 
 	respondWithJSON(w, http.StatusOK, response)
@@ -75,37 +175,380 @@ func (h *Handler) GetExpressionByIDHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	includeTasks := false
+	if include := r.URL.Query().Get("include"); include != "" {
+		for _, part := range strings.Split(include, ",") {
+			if part != "tasks" {
+				respondWithError(w, http.StatusUnprocessableEntity, "Invalid include: "+part)
+				return
+			}
+			includeTasks = true
+		}
+	}
+
 	response := models.ExpressionDetailResponse{
-		Expression: models.ExpressionResponse{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
-		},
+		Expression: newExpressionResponse(expr),
+	}
+	response.Expression.Expression = expr.Expression
+	response.Expression.Variables = expr.Variables
+	response.Expression.Webhook = expr.Webhook
+	// Stored expressions have been parsed successfully once already.
+	response.Expression.Canonical, _ = calculator.Canonicalize(expr.Expression)
+
+	if includeTasks {
+		tasks, err := h.service.GetExpressionTasks(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to load tasks")
+			return
+		}
+		response.Expression.Tasks = make([]models.TaskDetail, len(tasks))
+		for i, task := range tasks {
+			response.Expression.Tasks[i] = newTaskDetail(task)
+		}
 	}
 
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// newTaskDetail returns the debugging representation of a task.
+func newTaskDetail(task *models.Task) models.TaskDetail {
+	detail := models.TaskDetail{
+		ID:                  task.ID,
+		Operation:           task.Operation,
+		OperationTime:       task.OperationTime,
+		Args:                task.Args,
+		ExactArgs:           task.ExactArgs,
+		Dependencies:        make([]uuid.UUID, 0, len(task.Dependencies)),
+		Dependents:          task.Dependents,
+		PendingDependencies: task.PendingDependencies,
+		Status:              task.Status,
+		Result:              task.Result,
+		ExactResult:         task.ExactResult,
+		Error:               task.Error,
+		ErrorCode:           task.ErrorCode,
+		CreatedAt:           task.CreatedAt,
+		StartedAt:           task.StartedAt,
+		CompletedAt:         task.CompletedAt,
+	}
+	for _, dependency := range task.Dependencies {
+		if dependency != nil {
+			detail.Dependencies = append(detail.Dependencies, *dependency)
+		}
+	}
+	if detail.Dependents == nil {
+		detail.Dependents = []uuid.UUID{}
+	}
+	if task.AgentID != uuid.Nil {
+		agentID := task.AgentID
+		detail.AgentID = &agentID
+	}
+	if task.Status == models.TaskStatusProcessing {
+		detail.LeaseExpiresAt = task.LeaseExpiresAt
+	}
+	return detail
+}
+
+// newExpressionResponse returns the API representation of an expression,
+// without its text, variables and tasks.
+func newExpressionResponse(expr *models.Expression) models.ExpressionResponse {
+	response := models.ExpressionResponse{
+		ID:          expr.ID,
+		Status:      expr.Status,
+		Precision:   expr.Precision.Mode,
+		Result:      expr.Result,
+		ExactResult: expr.ExactResult,
+		Error:       expr.Error,
+		ErrorCode:   expr.ErrorCode,
+		CreatedAt:   expr.CreatedAt,
+		UpdatedAt:   expr.UpdatedAt,
+	}
+	if response.Precision == "" {
+		response.Precision = models.PrecisionFloat64
+	}
+	switch expr.Precision.Mode {
+	case models.PrecisionDecimal:
+		scale := expr.Precision.Scale
+		response.Scale = &scale
+	case models.PrecisionBigFloat:
+		bits := expr.Precision.Bits
+		response.Bits = &bits
+		if expr.Precision.Digits != 0 {
+			digits := expr.Precision.Digits
+			response.Digits = &digits
+		}
+		response.Rounding = expr.Precision.Rounding
+	}
+	return response
+}
+
+func (h *Handler) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid expression ID")
+		return
+	}
+
+	expr, err := h.service.CancelExpression(id)
+	if errors.Is(err, repository.ErrExpressionNotFound) {
+		respondWithError(w, http.StatusNotFound, "Expression not found")
+		return
+	}
+	if errors.Is(err, repository.ErrExpressionFinished) {
+		respondWithError(w, http.StatusConflict, "Expression has already finished")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to cancel expression")
+		return
+	}
+
+	response := models.ExpressionDetailResponse{
+		Expression: newExpressionResponse(expr),
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// maxTaskWait bounds how long GetTaskHandler may hold a long-poll request.
+const maxTaskWait = 60 * time.Second
+
+// maxTaskBatch bounds how many tasks GetTasksHandler leases at once.
+const maxTaskBatch = 100
+
+// GetTaskHandler hands out the next ready task. With a "wait" query
+// parameter (a duration such as "30s") the request blocks until a task is
+// ready or the wait elapses. Registered agents pass their "agent_id".
 func (h *Handler) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	task, err := h.service.GetNextTask()
+	agentID, ok := parseAgentID(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := parseWait(w, r)
+	if !ok {
+		return
+	}
+
+	task, err := h.service.GetNextTask(r.Context(), agentID, wait)
+	if errors.Is(err, service.ErrAgentDraining) {
+		respondWithError(w, http.StatusConflict, "Agent is draining")
+		return
+	}
+	if errors.Is(err, service.ErrShuttingDown) {
+		respondWithError(w, http.StatusServiceUnavailable, "Orchestrator is shutting down")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "No tasks available")
 		return
 	}
 
-	response := models.GetTaskResponse{
-		Task: &models.TaskResponse{
-			ID:            task.ID,
-			Arg1:          task.Arg1Value,
-			Arg2:          task.Arg2Value,
-			Operation:     task.Operation,
-			OperationTime: task.OperationTime,
-		},
+	taskResponse := newTaskResponse(task)
+	respondWithJSON(w, http.StatusOK, models.GetTaskResponse{Task: &taskResponse})
+}
+
+// GetTasksHandler leases up to "max" ready tasks (1 by default) in one
+// call, long-polling like GetTaskHandler.
+func (h *Handler) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	max := 1
+	if maxStr := r.URL.Query().Get("max"); maxStr != "" {
+		var err error
+		max, err = strconv.Atoi(maxStr)
+		if err != nil || max < 1 {
+			respondWithError(w, http.StatusUnprocessableEntity, "Invalid max")
+			return
+		}
+		if max > maxTaskBatch {
+			max = maxTaskBatch
+		}
+	}
+	agentID, ok := parseAgentID(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := parseWait(w, r)
+	if !ok {
+		return
+	}
+
+	tasks, err := h.service.GetNextTasks(r.Context(), agentID, max, wait)
+	if errors.Is(err, service.ErrAgentDraining) {
+		respondWithError(w, http.StatusConflict, "Agent is draining")
+		return
+	}
+	if errors.Is(err, service.ErrShuttingDown) {
+		respondWithError(w, http.StatusServiceUnavailable, "Orchestrator is shutting down")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "No tasks available")
+		return
+	}
+
+	response := models.GetTasksResponse{Tasks: make([]models.TaskResponse, 0, len(tasks))}
+	for _, task := range tasks {
+		response.Tasks = append(response.Tasks, newTaskResponse(task))
 	}
 
 	respondWithJSON(w, http.StatusOK, response)
 }
 
+// parseExpressionQuery reads the pagination, filter and sort query
+// parameters of the expression list, responding with an error and returning
+// false if any is invalid. The list defaults to the newest expressions
+// first.
+func parseExpressionQuery(w http.ResponseWriter, r *http.Request) (models.ExpressionQuery, bool) {
+	params := r.URL.Query()
+	query := models.ExpressionQuery{
+		Sort:       models.SortCreatedAt,
+		Descending: true,
+		Limit:      models.DefaultExpressionPageSize,
+	}
+
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > models.MaxExpressionPageSize {
+			respondWithError(w, http.StatusUnprocessableEntity,
+				"Invalid limit: must be between 1 and "+strconv.Itoa(models.MaxExpressionPageSize))
+			return query, false
+		}
+		query.Limit = limit
+	}
+
+	statuses, ok := parseStatuses(w, r)
+	if !ok {
+		return query, false
+	}
+	query.Statuses = statuses
+
+	for _, bound := range []struct {
+		param string
+		value *time.Time
+	}{
+		{"created_from", &query.CreatedFrom},
+		{"created_to", &query.CreatedTo},
+	} {
+		if s := params.Get(bound.param); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				respondWithError(w, http.StatusUnprocessableEntity, "Invalid "+bound.param+": must be an RFC 3339 time")
+				return query, false
+			}
+			*bound.value = t
+		}
+	}
+
+	switch sort := models.ExpressionSort(params.Get("sort")); sort {
+	case "":
+	case models.SortCreatedAt, models.SortUpdatedAt:
+		query.Sort = sort
+	default:
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid sort: "+string(sort))
+		return query, false
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Descending = false
+	default:
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid order: "+order)
+		return query, false
+	}
+
+	if cursorStr := params.Get("cursor"); cursorStr != "" {
+		cursor, err := models.ParseExpressionCursor(cursorStr)
+		if err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, "Invalid cursor")
+			return query, false
+		}
+		query.After = &cursor
+	}
+	return query, true
+}
+
+// parseStatuses reads the optional comma-separated "status" query
+// parameter, responding with an error and returning false if it is invalid.
+func parseStatuses(w http.ResponseWriter, r *http.Request) ([]models.ExpressionStatus, bool) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		return nil, true
+	}
+
+	var statuses []models.ExpressionStatus
+	for _, part := range strings.Split(status, ",") {
+		s := models.ExpressionStatus(strings.ToUpper(strings.TrimSpace(part)))
+		switch s {
+		case models.StatusPending, models.StatusComputing, models.StatusCompleted,
+			models.StatusError, models.StatusCancelled:
+			statuses = append(statuses, s)
+		default:
+			respondWithError(w, http.StatusUnprocessableEntity, "Invalid status: "+part)
+			return nil, false
+		}
+	}
+	return statuses, true
+}
+
+// parseWait reads the "wait" query parameter, responding with an error and
+// returning false if it is invalid.
+func parseWait(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	return parseDuration(w, r, "wait", 0, maxTaskWait)
+}
+
+// parseDuration reads a duration query parameter such as "30s", which
+// defaults to def and is capped at max, responding with an error and
+// returning false if it is invalid.
+func parseDuration(w http.ResponseWriter, r *http.Request, name string, def, max time.Duration) (time.Duration, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, true
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid "+name+" duration")
+		return 0, false
+	}
+	if d > max {
+		d = max
+	}
+	return d, true
+}
+
+// parseAgentID reads the optional "agent_id" query parameter, responding
+// with an error and returning false if it is invalid.
+func parseAgentID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	idStr := r.URL.Query().Get("agent_id")
+	if idStr == "" {
+		return uuid.Nil, true
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid agent ID")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func newTaskResponse(task *models.Task) models.TaskResponse {
+	response := models.TaskResponse{
+		ID:             task.ID,
+		Args:           task.Args,
+		Operation:      task.Operation,
+		OperationTime:  task.OperationTime,
+		LeaseToken:     task.LeaseToken,
+		LeaseExpiresAt: *task.LeaseExpiresAt,
+	}
+	if task.Precision.IsBig() {
+		precision := task.Precision
+		response.Precision = &precision
+		response.ExactArgs = task.ExactArgs
+	}
+	return response
+}
+
 func (h *Handler) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request) {
 	var req models.TaskResultRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -113,21 +556,161 @@ func (h *Handler) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err := h.service.UpdateTaskResult(req.ID, req.Result)
-	if err != nil {
+	err := h.service.SubmitTaskResult(req)
+	if errors.Is(err, repository.ErrTaskCancelled) {
+		// The expression was cancelled; acknowledge so the agent drops the task.
+		respondWithJSON(w, http.StatusOK, models.TaskResultResponse{Status: models.SubmitStatusCancelled})
+		return
+	}
+	if errors.Is(err, repository.ErrLeaseMismatch) {
+		respondWithError(w, http.StatusConflict, "Task lease expired or held by another agent")
+		return
+	}
+	if errors.Is(err, repository.ErrTaskNotFound) {
 		respondWithError(w, http.StatusNotFound, "Task not found")
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to submit task result")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.TaskResultResponse{Status: models.SubmitStatusSuccess})
+}
+
+// SubmitTaskResultsHandler records a batch of results. Items are applied
+// independently and the response reports the outcome of each in order.
+func (h *Handler) SubmitTaskResultsHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TaskResultsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	response := models.TaskResultsResponse{Results: make([]models.TaskResultItemResponse, 0, len(req.Results))}
+	for _, result := range req.Results {
+		response.Results = append(response.Results, submitResult(h.service, result))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// ReleaseTasksHandler takes back leased tasks that an agent will not
+// compute, typically because it is shutting down.
+func (h *Handler) ReleaseTasksHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TaskReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+		return
+	}
+
+	released, err := h.service.ReleaseTasks(req.Tasks)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to release tasks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.TaskReleaseResponse{Released: released})
+}
+
+// submitResult records one result and reports its outcome as an item of a
+// batched submission.
+func submitResult(svc *service.Service, result models.TaskResultRequest) models.TaskResultItemResponse {
+	item := models.TaskResultItemResponse{ID: result.ID, Status: models.SubmitStatusSuccess}
+
+	err := svc.SubmitTaskResult(result)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrTaskCancelled):
+		item.Status = models.SubmitStatusCancelled
+	case errors.Is(err, repository.ErrLeaseMismatch):
+		item.Status = models.SubmitStatusConflict
+		item.Error = "Task lease expired or held by another agent"
+	case errors.Is(err, repository.ErrTaskNotFound):
+		item.Status = models.SubmitStatusNotFound
+		item.Error = "Task not found"
+	default:
+		item.Status = models.SubmitStatusError
+		item.Error = err.Error()
+	}
+
+	return item
+}
+
+func (h *Handler) RegisterAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterAgentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+		return
+	}
+
+	if req.ID == uuid.Nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Agent ID is required")
+		return
+	}
+	if req.WorkerCount < 1 {
+		respondWithError(w, http.StatusUnprocessableEntity, "Worker count must be positive")
+		return
+	}
+
+	agent := h.service.RegisterAgent(req)
+
+	respondWithJSON(w, http.StatusOK, models.AgentResponse{Agent: agent})
+}
+
+func (h *Handler) HeartbeatAgentHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid agent ID")
+		return
+	}
+
+	if err := h.service.HeartbeatAgent(id); err != nil {
+		// Unknown or dead; the agent has to register again.
+		respondWithError(w, http.StatusNotFound, "Agent not registered")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DrainAgentHandler tells an agent to stop taking work; it finishes its
+// in-flight tasks and shuts down.
+func (h *Handler) DrainAgentHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid agent ID")
+		return
+	}
+
+	agent, err := h.service.DrainAgent(id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, models.AgentResponse{Agent: agent})
+}
+
+func (h *Handler) GetAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, models.AgentsResponse{Agents: h.service.GetAgents()})
 }
 
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
 
+// respondWithJSON writes payload with the given status, or a 500 if it
+// cannot be encoded.
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload) 
+	response, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		code = http.StatusInternalServerError
+		response, _ = json.Marshal(map[string]string{"error": "Failed to encode response"})
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
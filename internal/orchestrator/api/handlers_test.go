@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/service"
+)
+
+// startHTTPServer serves the handlers of svc on the routes the orchestrator
+// mounts them on.
+func startHTTPServer(t *testing.T, svc *service.Service) *httptest.Server {
+	t.Helper()
+	handler := NewHandler(svc)
+	r := chi.NewRouter()
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Post("/calculate", handler.CalculateHandler)
+		r.Get("/expressions/{id}", handler.GetExpressionByIDHandler)
+		r.Get("/expressions/{id}/events", handler.ExpressionEventsHandler)
+		r.Delete("/expressions/{id}", handler.CancelExpressionHandler)
+	})
+	r.Route("/internal", func(r chi.Router) {
+		r.Get("/task", handler.GetTaskHandler)
+		r.Post("/task", handler.SubmitTaskResultHandler)
+		r.Get("/tasks", handler.GetTasksHandler)
+		r.Post("/tasks", handler.SubmitTaskResultsHandler)
+	})
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// doJSON sends body as JSON, decodes the response into out if it is not nil
+// and returns the status code.
+func doJSON(t *testing.T, method, url string, body, out interface{}) int {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func submitExpression(t *testing.T, server *httptest.Server, expression string) uuid.UUID {
+	t.Helper()
+	var created models.CalculateResponse
+	code := doJSON(t, http.MethodPost, server.URL+"/api/v1/calculate", models.CalculateRequest{Expression: expression}, &created)
+	if code != http.StatusCreated {
+		t.Fatalf("calculate %q: status %d", expression, code)
+	}
+	return created.ID
+}
+
+// TestCancelExpression cancels an expression with a task in flight: the
+// agent's result is then acknowledged as cancelled, single or batched.
+func TestCancelExpression(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+
+	id := submitExpression(t, server, "(1 + 2) * (3 + 4)")
+	var leased models.GetTasksResponse
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/tasks?max=2", nil, &leased); code != http.StatusOK || len(leased.Tasks) != 2 {
+		t.Fatalf("fetch: status %d, %d tasks", code, len(leased.Tasks))
+	}
+
+	for range 2 {
+		// Cancelling twice is not an error.
+		var detail models.ExpressionDetailResponse
+		code := doJSON(t, http.MethodDelete, server.URL+"/api/v1/expressions/"+id.String(), nil, &detail)
+		if code != http.StatusOK || detail.Expression.Status != models.StatusCancelled {
+			t.Fatalf("cancel: status %d, expression %s", code, detail.Expression.Status)
+		}
+	}
+
+	first, second := leased.Tasks[0], leased.Tasks[1]
+	var single models.TaskResultResponse
+	code := doJSON(t, http.MethodPost, server.URL+"/internal/task", models.TaskResultRequest{ID: first.ID, LeaseToken: first.LeaseToken, Result: 3}, &single)
+	if code != http.StatusOK || single.Status != models.SubmitStatusCancelled {
+		t.Errorf("submit: status %d, %q, want %d %q", code, single.Status, http.StatusOK, models.SubmitStatusCancelled)
+	}
+	var batch models.TaskResultsResponse
+	results := models.TaskResultsRequest{Results: []models.TaskResultRequest{{ID: second.ID, LeaseToken: second.LeaseToken, Result: 7}}}
+	code = doJSON(t, http.MethodPost, server.URL+"/internal/tasks", results, &batch)
+	if code != http.StatusOK || len(batch.Results) != 1 || batch.Results[0].Status != models.SubmitStatusCancelled {
+		t.Errorf("batch submit: status %d, %+v, want one %q item", code, batch.Results, models.SubmitStatusCancelled)
+	}
+
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/task", nil, nil); code != http.StatusNotFound {
+		t.Errorf("fetch after cancelling: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestCancelExpressionErrors(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+
+	id := submitExpression(t, server, "1 + 2")
+	var task models.GetTaskResponse
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/task", nil, &task); code != http.StatusOK {
+		t.Fatalf("fetch: status %d", code)
+	}
+	doJSON(t, http.MethodPost, server.URL+"/internal/task", models.TaskResultRequest{ID: task.Task.ID, LeaseToken: task.Task.LeaseToken, Result: 3}, nil)
+
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{id.String(), http.StatusConflict},
+		{uuid.NewString(), http.StatusNotFound},
+		{"not-an-id", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if code := doJSON(t, http.MethodDelete, server.URL+"/api/v1/expressions/"+tt.id, nil, nil); code != tt.want {
+			t.Errorf("cancel %s: status %d, want %d", tt.id, code, tt.want)
+		}
+	}
+}
+
+func TestBatchedTasks(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+
+	first := submitExpression(t, server, "1 + 2")
+	second := submitExpression(t, server, "3 + 4")
+	submitExpression(t, server, "5 + 6")
+
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/tasks?max=0", nil, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("max=0: status %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	var leased models.GetTasksResponse
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/tasks?max=2", nil, &leased); code != http.StatusOK || len(leased.Tasks) != 2 {
+		t.Fatalf("fetch: status %d, %d tasks, want 2", code, len(leased.Tasks))
+	}
+
+	// Items are applied independently and reported in order.
+	a, b := leased.Tasks[0], leased.Tasks[1]
+	results := models.TaskResultsRequest{Results: []models.TaskResultRequest{
+		{ID: a.ID, LeaseToken: a.LeaseToken, Result: 3},
+		{ID: b.ID, LeaseToken: uuid.New(), Result: 7},
+		{ID: uuid.New(), LeaseToken: b.LeaseToken, Result: 7},
+		{ID: b.ID, LeaseToken: b.LeaseToken, Result: 7},
+	}}
+	want := []string{models.SubmitStatusSuccess, models.SubmitStatusConflict, models.SubmitStatusNotFound, models.SubmitStatusSuccess}
+	var batch models.TaskResultsResponse
+	if code := doJSON(t, http.MethodPost, server.URL+"/internal/tasks", results, &batch); code != http.StatusOK || len(batch.Results) != len(want) {
+		t.Fatalf("submit: status %d, %d items, want %d", code, len(batch.Results), len(want))
+	}
+	for i, item := range batch.Results {
+		if item.ID != results.Results[i].ID || item.Status != want[i] {
+			t.Errorf("item %d: %s %q, want %s %q", i, item.ID, item.Status, results.Results[i].ID, want[i])
+		}
+	}
+
+	for _, id := range []uuid.UUID{first, second} {
+		var detail models.ExpressionDetailResponse
+		doJSON(t, http.MethodGet, server.URL+"/api/v1/expressions/"+id.String(), nil, &detail)
+		if detail.Expression.Status != models.StatusCompleted {
+			t.Errorf("expression %s is %s, want COMPLETED", id, detail.Expression.Status)
+		}
+	}
+
+	// Fewer tasks than asked for are returned rather than waited for.
+	if code := doJSON(t, http.MethodGet, server.URL+"/internal/tasks?max=5&wait=1m", nil, &leased); code != http.StatusOK || len(leased.Tasks) != 1 {
+		t.Errorf("fetch: status %d, %d tasks, want 1", code, len(leased.Tasks))
+	}
+}
+
+func TestExpressionDetail(t *testing.T) {
+	svc := newTestService(t)
+	server := startHTTPServer(t, svc)
+	agentID := svc.RegisterAgent(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1}).ID
+
+	var created models.CalculateResponse
+	req := models.CalculateRequest{Expression: "2*x + 1", Variables: map[string]float64{"x": 3}}
+	if code := doJSON(t, http.MethodPost, server.URL+"/api/v1/calculate", req, &created); code != http.StatusCreated {
+		t.Fatalf("calculate: status %d", code)
+	}
+	url := server.URL + "/api/v1/expressions/" + created.ID.String()
+
+	var detail models.ExpressionDetailResponse
+	if code := doJSON(t, http.MethodGet, url, nil, &detail); code != http.StatusOK {
+		t.Fatalf("detail: status %d", code)
+	}
+	expr := detail.Expression
+	if expr.Expression != req.Expression || expr.Canonical != "((2 * x) + 1)" || expr.Variables["x"] != 3 || expr.Tasks != nil {
+		t.Errorf("detail is %q, canonical %q, variables %v, %d tasks; want %q, ((2 * x) + 1), x=3 and no tasks",
+			expr.Expression, expr.Canonical, expr.Variables, len(expr.Tasks), req.Expression)
+	}
+
+	leased, err := svc.GetNextTask(t.Context(), agentID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := doJSON(t, http.MethodGet, url+"?include=tasks", nil, &detail); code != http.StatusOK {
+		t.Fatalf("detail with tasks: status %d", code)
+	}
+
+	tasks := make(map[uuid.UUID]models.TaskDetail)
+	for _, task := range detail.Expression.Tasks {
+		tasks[task.ID] = task
+	}
+	roots := 0
+	for _, task := range tasks {
+		for _, id := range task.Dependencies {
+			if !slices.Contains(tasks[id].Dependents, task.ID) {
+				t.Errorf("task %s depends on %s, which does not list it as a dependent", task.ID, id)
+			}
+		}
+		if len(task.Dependents) == 0 {
+			roots++
+			if task.Operation != models.OperationAddition || task.PendingDependencies != 1 || task.Status != models.TaskStatusPending {
+				t.Errorf("root is %s %s with %d pending dependencies, want a PENDING ADDITION with 1", task.Status, task.Operation, task.PendingDependencies)
+			}
+		}
+	}
+	if roots != 1 {
+		t.Errorf("%d root tasks, want 1", roots)
+	}
+
+	task, ok := tasks[leased.ID]
+	switch {
+	case !ok:
+		t.Errorf("leased task %s not listed", leased.ID)
+	case task.Status != models.TaskStatusProcessing || task.AgentID == nil || *task.AgentID != agentID:
+		t.Errorf("leased task is %s for agent %v, want PROCESSING for %s", task.Status, task.AgentID, agentID)
+	case task.StartedAt == nil || task.LeaseExpiresAt == nil || task.Args[1] != 3:
+		t.Errorf("leased task started %v, lease until %v, args %v; want timestamps and x bound to 3", task.StartedAt, task.LeaseExpiresAt, task.Args)
+	}
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{url + "?include=agents", http.StatusUnprocessableEntity},
+		{server.URL + "/api/v1/expressions/" + uuid.NewString(), http.StatusNotFound},
+		{server.URL + "/api/v1/expressions/not-an-id", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		if code := doJSON(t, http.MethodGet, tt.url, nil, nil); code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.url, code, tt.want)
+		}
+	}
+}
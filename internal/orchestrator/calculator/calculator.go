@@ -2,12 +2,16 @@ package calculator
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/operations"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 )
 
 type Calculator struct {
@@ -15,6 +19,14 @@ type Calculator struct {
 	SubtractionTime    int
 	MultiplicationTime int
 	DivisionTime       int
+	NegationTime       int
+	PowerTime          int
+	// FunctionTimes holds the simulated cost of each built-in function,
+	// configured with TIME_<FUNCTION>_MS.
+	FunctionTimes map[models.OperationType]int
+	// NonFinite is the policy for results that are ±Inf or NaN, configured
+	// with NON_FINITE_POLICY.
+	NonFinite models.NonFinitePolicy
 }
 
 func NewCalculator() *Calculator {
@@ -22,12 +34,33 @@ func NewCalculator() *Calculator {
 	subTime, _ := strconv.Atoi(getEnvOrDefault("TIME_SUBTRACTION_MS", "1000"))
 	mulTime, _ := strconv.Atoi(getEnvOrDefault("TIME_MULTIPLICATIONS_MS", "2000"))
 	divTime, _ := strconv.Atoi(getEnvOrDefault("TIME_DIVISIONS_MS", "2000"))
+	negTime, _ := strconv.Atoi(getEnvOrDefault("TIME_NEGATION_MS", "1000"))
+	powTime, _ := strconv.Atoi(getEnvOrDefault("TIME_POWER_MS", "2000"))
+
+	functionTimes := make(map[models.OperationType]int)
+	for _, op := range operations.Functions() {
+		key := fmt.Sprintf("TIME_%s_MS", strings.ToUpper(op.Function))
+		opTime, err := strconv.Atoi(getEnvOrDefault(key, strconv.Itoa(op.DefaultTime)))
+		if err != nil {
+			opTime = op.DefaultTime
+		}
+		functionTimes[op.Type] = opTime
+	}
+
+	nonFinite := models.NonFinitePolicy(getEnvOrDefault("NON_FINITE_POLICY", string(models.NonFiniteError)))
+	if nonFinite != models.NonFiniteString {
+		nonFinite = models.NonFiniteError
+	}
 
 	return &Calculator{
 		AdditionTime:       addTime,
 		SubtractionTime:    subTime,
 		MultiplicationTime: mulTime,
 		DivisionTime:       divTime,
+		NegationTime:       negTime,
+		PowerTime:          powTime,
+		FunctionTimes:      functionTimes,
+		NonFinite:          nonFinite,
 	}
 }
 
@@ -39,14 +72,61 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
-func (c *Calculator) ProcessExpression(expression string, expressionID uuid.UUID) ([]*models.Task, error) {
+// UnboundVariablesError is returned when an expression references variables
+// that have no value bound to them.
+type UnboundVariablesError struct {
+	Names []string
+}
+
+func (e *UnboundVariablesError) Error() string {
+	return fmt.Sprintf("unbound variables: %s", strings.Join(e.Names, ", "))
+}
+
+// UnsupportedPrecisionError is returned when an expression uses operations
+// that cannot be evaluated in its precision.
+type UnsupportedPrecisionError struct {
+	Operation string
+	Precision models.PrecisionMode
+}
+
+func (e *UnsupportedPrecisionError) Error() string {
+	return fmt.Sprintf("%s is not supported in the %s precision", e.Operation, e.Precision)
+}
+
+// NumberRangeError is returned for a number that the precision of its
+// expression cannot represent, such as 1e400 in the float64 precision.
+type NumberRangeError struct {
+	Literal   string
+	Precision models.PrecisionMode
+}
+
+func (e *NumberRangeError) Error() string {
+	return fmt.Sprintf("number %s is out of range of the %s precision", e.Literal, e.Precision)
+}
+
+// NonIntegerError is returned for a number that is not whole in an integer
+// precision.
+type NonIntegerError struct {
+	Literal   string
+	Precision models.PrecisionMode
+}
+
+func (e *NonIntegerError) Error() string {
+	return fmt.Sprintf("number %s is not an integer, as the %s precision requires", e.Literal, e.Precision)
+}
+
+func (c *Calculator) ProcessExpression(expression string, variables map[string]float64, precision models.Precision, expressionID uuid.UUID) ([]*models.Task, error) {
 	parser := NewParser(expression)
 	ast, err := parser.Parse()
 	if err != nil {
 		return nil, err
 	}
 
-	tasks, err := c.convertASTToTasks(ast, expressionID)
+	if missing := findUnboundVariables(ast, variables); len(missing) > 0 {
+		return nil, &UnboundVariablesError{Names: missing}
+	}
+
+	tasks, err := c.convertASTToTasks(ast, variables, precision, expressionID)
 	if err != nil {
 		return nil, err
 	}
@@ -54,66 +134,168 @@ func (c *Calculator) ProcessExpression(expression string, expressionID uuid.UUID
 	return tasks, nil
 }
 
-func (c *Calculator) convertASTToTasks(node ASTNode, expressionID uuid.UUID) ([]*models.Task, error) {
-	var tasks []*models.Task
-	switch n := node.(type) {
-	case *NumberNode:
-		task := &models.Task{
-			ID:           uuid.New(),
-			ExpressionID: expressionID,
-			Operation:    models.OperationValue,
-			Status:       models.TaskStatusCompleted, // Numbers are already calculated
-			CreatedAt:    time.Now(),
+// Canonicalize returns the expression fully parenthesized as it is parsed,
+// e.g. (1 + (2 * 3)) for 1 + 2 * 3.
+func Canonicalize(expression string) (string, error) {
+	ast, err := NewParser(expression).Parse()
+	if err != nil {
+		return "", err
+	}
+	return ast.String(), nil
+}
+
+// findUnboundVariables returns the sorted names of the variables referenced
+// by the AST that have no binding.
+func findUnboundVariables(node ASTNode, variables map[string]float64) []string {
+	missing := make(map[string]bool)
+	var walk func(node ASTNode)
+	walk = func(node ASTNode) {
+		switch n := node.(type) {
+		case *VariableNode:
+			if _, ok := variables[n.Name]; !ok {
+				missing[n.Name] = true
+			}
+		case *BinaryOpNode:
+			walk(n.Left)
+			walk(n.Right)
+		case *UnaryOpNode:
+			walk(n.Operand)
+		case *FunctionCallNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
 		}
-		result := n.Value
-		task.Result = &result
-		tasks = append(tasks, task)
-		return tasks, nil
+	}
+	walk(node)
 
-	case *BinaryOpNode:
-		leftTasks, err := c.convertASTToTasks(n.Left, expressionID)
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Calculator) convertASTToTasks(node ASTNode, variables map[string]float64, precision models.Precision, expressionID uuid.UUID) ([]*models.Task, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		task, err := newValueTask(n.Value, n.Literal, precision, expressionID)
 		if err != nil {
 			return nil, err
 		}
+		return []*models.Task{task}, nil
 
-		rightTasks, err := c.convertASTToTasks(n.Right, expressionID)
+	case *VariableNode:
+		value, ok := variables[n.Name]
+		if !ok {
+			return nil, &UnboundVariablesError{Names: []string{n.Name}}
+		}
+		// Variables are bound as float64; their shortest decimal form is
+		// what the client meant, e.g. 0.1 rather than its binary value.
+		literal := strconv.FormatFloat(value, 'g', -1, 64)
+		task, err := newValueTask(value, literal, precision, expressionID)
 		if err != nil {
 			return nil, err
 		}
+		return []*models.Task{task}, nil
 
-		leftTask := leftTasks[len(leftTasks)-1]
-		rightTask := rightTasks[len(rightTasks)-1]
-
+	case *BinaryOpNode:
 		operationType, opTime := c.getOperationTypeAndTime(n.Op)
-		
-		task := &models.Task{
-			ID:            uuid.New(),
-			ExpressionID:  expressionID,
-			Arg1:          leftTask,
-			Arg2:          rightTask,
-			Operation:     operationType,
-			OperationTime: opTime,
-			Status:        models.TaskStatusPending,
-			Dependencies:  []*uuid.UUID{&leftTask.ID, &rightTask.ID},
-			CreatedAt:     time.Now(),
+		return c.convertOperationToTasks(operationType, opTime, []ASTNode{n.Left, n.Right}, variables, precision, expressionID)
+
+	case *UnaryOpNode:
+		operationType, opTime := c.getUnaryOperationTypeAndTime(n.Op)
+		return c.convertOperationToTasks(operationType, opTime, []ASTNode{n.Operand}, variables, precision, expressionID)
+
+	case *FunctionCallNode:
+		op, ok := operations.LookupFunction(n.Name)
+		if !ok {
+			return nil, fmt.Errorf("unknown function: %s", n.Name)
+		}
+		if !op.SupportsPrecision(precision) {
+			return nil, &UnsupportedPrecisionError{Operation: n.Name, Precision: precision.Mode}
 		}
+		return c.convertOperationToTasks(op.Type, c.FunctionTimes[op.Type], n.Args, variables, precision, expressionID)
+	}
 
-		if leftTask.Result != nil {
-			task.Arg1Value = *leftTask.Result
+	return nil, fmt.Errorf("unknown node type")
+}
+
+// newValueTask returns the completed task holding a literal or variable
+// value. The math/big precisions take the value from its decimal text.
+func newValueTask(value float64, literal string, precision models.Precision, expressionID uuid.UUID) (*models.Task, error) {
+	task := &models.Task{
+		ID:           uuid.New(),
+		ExpressionID: expressionID,
+		Operation:    models.OperationValue,
+		Status:       models.TaskStatusCompleted, // Values are already calculated
+		CreatedAt:    time.Now(),
+		Precision:    precision,
+	}
+	if precision.IsInteger() && !operations.IsInteger(literal) {
+		return nil, &NonIntegerError{Literal: literal, Precision: precision.Mode}
+	}
+	if precision.IsBig() {
+		result, approx, ok := operations.ParseLiteral(literal, precision)
+		if !ok {
+			return nil, &NumberRangeError{Literal: literal, Precision: precision.Mode}
 		}
-		
-		if rightTask.Result != nil {
-			task.Arg2Value = *rightTask.Result
+		task.ExactArgs = []string{result}
+		task.ExactResult = &result
+		value = approx
+	} else if math.IsInf(value, 0) {
+		return nil, &NumberRangeError{Literal: literal, Precision: models.PrecisionFloat64}
+	}
+	result := models.Float(value)
+	task.Args = []models.Float{result}
+	task.Result = &result
+	return task, nil
+}
+
+// convertOperationToTasks converts the operands of an operation to tasks and
+// appends the task applying the operation to their results.
+func (c *Calculator) convertOperationToTasks(operationType models.OperationType, opTime int, operands []ASTNode, variables map[string]float64, precision models.Precision, expressionID uuid.UUID) ([]*models.Task, error) {
+	var tasks []*models.Task
+
+	task := &models.Task{
+		ID:            uuid.New(),
+		ExpressionID:  expressionID,
+		Args:          make([]models.Float, len(operands)),
+		Operation:     operationType,
+		OperationTime: opTime,
+		Status:        models.TaskStatusPending,
+		Dependencies:  make([]*uuid.UUID, 0, len(operands)),
+		CreatedAt:     time.Now(),
+		Precision:     precision,
+	}
+	if precision.IsBig() {
+		task.ExactArgs = make([]string, len(operands))
+	}
+
+	for i, operand := range operands {
+		operandTasks, err := c.convertASTToTasks(operand, variables, precision, expressionID)
+		if err != nil {
+			return nil, err
+		}
+
+		argTask := operandTasks[len(operandTasks)-1]
+		task.Dependencies = append(task.Dependencies, &argTask.ID)
+		if argTask.Result != nil {
+			task.Args[i] = *argTask.Result
+			if argTask.ExactResult != nil {
+				task.ExactArgs[i] = *argTask.ExactResult
+			}
+		} else {
+			task.PendingDependencies++
 		}
+		argTask.Dependents = append(argTask.Dependents, task.ID)
 
-		tasks = append(tasks, leftTasks...)
-		tasks = append(tasks, rightTasks...)
-		tasks = append(tasks, task)
-		
-		return tasks, nil
+		tasks = append(tasks, operandTasks...)
 	}
 
-	return nil, fmt.Errorf("unknown node type")
+	tasks = append(tasks, task)
+
+	return tasks, nil
 }
 
 func (c *Calculator) getOperationTypeAndTime(op string) (models.OperationType, int) {
@@ -126,34 +308,64 @@ func (c *Calculator) getOperationTypeAndTime(op string) (models.OperationType, i
 		return models.OperationMultiplication, c.MultiplicationTime
 	case "/":
 		return models.OperationDivision, c.DivisionTime
+	case "//":
+		return models.OperationFloorDivision, c.DivisionTime
+	case "%":
+		return models.OperationModulo, c.DivisionTime
+	case "^":
+		return models.OperationPower, c.PowerTime
 	default:
 		return "", 0
 	}
 }
 
-func (c *Calculator) ExecuteOperation(op models.OperationType, arg1, arg2 float64) (float64, error) {
+func (c *Calculator) getUnaryOperationTypeAndTime(op string) (models.OperationType, int) {
 	switch op {
-	case models.OperationAddition:
-		return arg1 + arg2, nil
-	case models.OperationSubtraction:
-		return arg1 - arg2, nil
-	case models.OperationMultiplication:
-		return arg1 * arg2, nil
-	case models.OperationDivision:
-		if arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
-		}
-		return arg1 / arg2, nil
-	case models.OperationValue:
-		return arg1, nil // Just return the value
+	case "-":
+		return models.OperationNegation, c.NegationTime
 	default:
-		return 0, fmt.Errorf("unknown operation type: %s", op)
+		return "", 0
 	}
 }
 
-func (c *Calculator) UpdateTaskResult(task *models.Task, result float64) {
+func (c *Calculator) ExecuteOperation(op models.OperationType, args []float64) (float64, error) {
+	return operations.Execute(op, args)
+}
+
+// ExecuteBigOperation is the counterpart of ExecuteOperation for the
+// math/big precisions, on values encoded as strings.
+func (c *Calculator) ExecuteBigOperation(op models.OperationType, precision models.Precision, args []string) (string, error) {
+	return operations.ExecuteBig(op, precision, args)
+}
+
+// UpdateTaskResult completes a task. In the math/big precisions the result
+// is taken from the exact one, normalized, and a missing or malformed exact
+// result fails the task instead. Under the NonFiniteError policy, so does a
+// result that is ±Inf or NaN.
+func (c *Calculator) UpdateTaskResult(task *models.Task, result models.Float, exact string) {
+	if task.Precision.IsBig() {
+		normalized, approx, ok := operations.NormalizeBig(exact, task.Precision)
+		if !ok {
+			c.FailTask(task, models.NewTaskError(models.ErrorCodeExecutionFailed, "invalid exact result %q", exact))
+			return
+		}
+		task.ExactResult = &normalized
+		result = models.Float(approx)
+	}
+	if !result.IsFinite() && c.NonFinite != models.NonFiniteString {
+		c.FailTask(task, models.NewTaskError(models.ErrorCodeNonFinite, "result of %s is %v", task.Operation, float64(result)))
+		return
+	}
 	task.Result = &result
 	task.Status = models.TaskStatusCompleted
 	now := time.Now()
 	task.CompletedAt = &now
 }
+
+func (c *Calculator) FailTask(task *models.Task, taskErr *models.TaskError) {
+	task.Error = taskErr.Message
+	task.ErrorCode = taskErr.Code
+	task.Status = models.TaskStatusError
+	now := time.Now()
+	task.CompletedAt = &now
+}
@@ -0,0 +1,52 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+func TestUpdateTaskResultNonFinite(t *testing.T) {
+	float64s := models.Precision{Mode: models.PrecisionFloat64}
+	rational := models.Precision{Mode: models.PrecisionRational}
+
+	tests := []struct {
+		name      string
+		policy    models.NonFinitePolicy
+		precision models.Precision
+		result    float64
+		exact     string
+		status    models.TaskStatus
+		code      models.TaskErrorCode
+	}{
+		{"finite", models.NonFiniteError, float64s, 1.5, "", models.TaskStatusCompleted, ""},
+		{"inf fails", models.NonFiniteError, float64s, math.Inf(1), "", models.TaskStatusError, models.ErrorCodeNonFinite},
+		{"negative inf fails", models.NonFiniteError, float64s, math.Inf(-1), "", models.TaskStatusError, models.ErrorCodeNonFinite},
+		{"nan fails", models.NonFiniteError, float64s, math.NaN(), "", models.TaskStatusError, models.ErrorCodeNonFinite},
+		{"inf kept", models.NonFiniteString, float64s, math.Inf(1), "", models.TaskStatusCompleted, ""},
+		{"nan kept", models.NonFiniteString, float64s, math.NaN(), "", models.TaskStatusCompleted, ""},
+		// Exact results beyond float64 saturate their approximation rather
+		// than overflow to Inf.
+		{"exact beyond float64", models.NonFiniteError, rational, 0, "1e400", models.TaskStatusCompleted, ""},
+		{"exact malformed", models.NonFiniteString, rational, 0, "1/0", models.TaskStatusError, models.ErrorCodeExecutionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Calculator{NonFinite: tt.policy}
+			task := &models.Task{Operation: models.OperationMultiplication, Precision: tt.precision}
+			c.UpdateTaskResult(task, models.Float(tt.result), tt.exact)
+
+			if task.Status != tt.status || task.ErrorCode != tt.code {
+				t.Fatalf("status %s (%s: %s), want %s (%s)", task.Status, task.ErrorCode, task.Error, tt.status, tt.code)
+			}
+			if task.Status == models.TaskStatusCompleted && task.Result == nil {
+				t.Fatal("completed task has no result")
+			}
+			if tt.exact != "" && task.Status == models.TaskStatusCompleted && float64(*task.Result) != math.MaxFloat64 {
+				t.Errorf("approximate result %v, want %v", float64(*task.Result), math.MaxFloat64)
+			}
+		})
+	}
+}
@@ -1,5 +1,9 @@
 package calculator
-import "fmt"
+
+import (
+	"fmt"
+	"strings"
+)
 
 type ASTNode interface {
 	String() string
@@ -7,12 +11,27 @@ type ASTNode interface {
 
 type NumberNode struct {
 	Value float64
+	// Literal is the number as written, which the math/big precisions
+	// evaluate without going through float64. Value is ±Inf for literals out
+	// of the float64 range.
+	Literal string
 }
 
 func (n *NumberNode) String() string {
+	if n.Literal != "" {
+		return n.Literal
+	}
 	return fmt.Sprintf("%g", n.Value)
 }
 
+type VariableNode struct {
+	Name string
+}
+
+func (n *VariableNode) String() string {
+	return n.Name
+}
+
 type BinaryOpNode struct {
 	Left  ASTNode
 	Op    string
@@ -23,4 +42,24 @@ func (n *BinaryOpNode) String() string {
 	return fmt.Sprintf("(%s %s %s)", n.Left.String(), n.Op, n.Right.String())
 }
 
+type FunctionCallNode struct {
+	Name string
+	Args []ASTNode
+}
 
+func (n *FunctionCallNode) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+}
+
+type UnaryOpNode struct {
+	Op      string
+	Operand ASTNode
+}
+
+func (n *UnaryOpNode) String() string {
+	return fmt.Sprintf("(%s%s)", n.Op, n.Operand.String())
+}
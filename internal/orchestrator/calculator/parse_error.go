@@ -0,0 +1,44 @@
+package calculator
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	expectedOperand   = []string{"number", "variable", "function call", "(", "-", "+"}
+	expectedOperators = []string{"+", "-", "*", "/", "//", "%", "^"}
+)
+
+// ParseError describes a syntax error in an expression. Position is the byte
+// offset of the offending token in the expression text.
+type ParseError struct {
+	Message  string   `json:"message"`
+	Position int      `json:"position"`
+	Expected []string `json:"expected,omitempty"`
+	Snippet  string   `json:"snippet"`
+}
+
+func newParseError(expression string, position int, message string, expected ...string) *ParseError {
+	return &ParseError{
+		Message:  message,
+		Position: position,
+		Expected: expected,
+		Snippet:  caretSnippet(expression, position),
+	}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+// caretSnippet renders the expression with a caret under the given byte
+// offset:
+//
+//	2 + * 3
+//	    ^
+func caretSnippet(expression string, position int) string {
+	column := utf8.RuneCountInString(expression[:position])
+	return expression + "\n" + strings.Repeat(" ", column) + "^"
+}
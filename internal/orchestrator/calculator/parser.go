@@ -1,62 +1,147 @@
 package calculator
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"unicode"
+
+	"github.com/popvictor123/distributed-calc/internal/operations"
 )
 
+type token struct {
+	text string
+	// pos is the byte offset of the token in the expression.
+	pos int
+}
+
 type Parser struct {
-	tokens []string
-	pos    int
+	expression string
+	tokens     []token
+	pos        int
 }
 
 func NewParser(expression string) *Parser {
 	tokens := tokenize(expression)
 	return &Parser{
-		tokens: tokens,
-		pos:    0,
+		expression: expression,
+		tokens:     tokens,
+		pos:        0,
 	}
 }
 
-func tokenize(expression string) []string {
-	var tokens []string
+func tokenize(expression string) []token {
+	var tokens []token
 	var currentToken strings.Builder
+	start := 0
+
+	flush := func() {
+		if currentToken.Len() > 0 {
+			tokens = append(tokens, token{text: currentToken.String(), pos: start})
+			currentToken.Reset()
+		}
+	}
+
+	skipNext := false
+	for i, char := range expression {
+		if skipNext {
+			skipNext = false
+			continue
+		}
 
-	for _, char := range expression {
 		if unicode.IsSpace(char) {
-			if currentToken.Len() > 0 {
-				tokens = append(tokens, currentToken.String())
-				currentToken.Reset()
+			flush()
+		} else if (char == '+' || char == '-') && isExponentPrefix(currentToken.String()) {
+			// The sign belongs to the exponent of a literal such as 1e-3.
+			currentToken.WriteRune(char)
+		} else if isOperator(string(char)) || char == '(' || char == ')' || char == ',' {
+			flush()
+			if strings.HasPrefix(expression[i:], "**") {
+				// "**" is an alias for "^".
+				tokens = append(tokens, token{text: "^", pos: i})
+				skipNext = true
+				continue
 			}
-		} else if isOperator(string(char)) || char == '(' || char == ')' {
-			if currentToken.Len() > 0 {
-				tokens = append(tokens, currentToken.String())
-				currentToken.Reset()
+			if strings.HasPrefix(expression[i:], "//") {
+				tokens = append(tokens, token{text: "//", pos: i})
+				skipNext = true
+				continue
 			}
-			tokens = append(tokens, string(char))
+			tokens = append(tokens, token{text: string(char), pos: i})
 		} else {
+			if currentToken.Len() == 0 {
+				start = i
+			}
 			currentToken.WriteRune(char)
 		}
 	}
-
-	if currentToken.Len() > 0 {
-		tokens = append(tokens, currentToken.String())
-	}
+	flush()
 
 	return tokens
 }
 
 func isOperator(s string) bool {
-	return s == "+" || s == "-" || s == "*" || s == "/"
+	return s == "+" || s == "-" || s == "*" || s == "/" || s == "%" || s == "^"
+}
+
+// isExponentPrefix reports whether s is a decimal mantissa followed by an
+// exponent marker, e.g. "1e" or "2.5E".
+func isExponentPrefix(s string) bool {
+	if len(s) < 2 || (s[len(s)-1] != 'e' && s[len(s)-1] != 'E') {
+		return false
+	}
+
+	digits, dots := 0, 0
+	for _, char := range s[:len(s)-1] {
+		switch {
+		case char >= '0' && char <= '9':
+			digits++
+		case char == '.':
+			dots++
+		default:
+			return false
+		}
+	}
+	return digits > 0 && dots <= 1
 }
 
+// Parse parses the whole expression. Syntax errors are returned as
+// *ParseError.
 func (p *Parser) Parse() (ASTNode, error) {
 	if len(p.tokens) == 0 {
-		return nil, fmt.Errorf("empty expression")
+		return nil, newParseError(p.expression, 0, "empty expression", expectedOperand...)
+	}
+
+	ast, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		expected := append(append([]string{}, expectedOperators...), "end of expression")
+		return nil, newParseError(p.expression, tok.pos, fmt.Sprintf("unexpected token: %s", tok.text), expected...)
+	}
+
+	return ast, nil
+}
+
+// peek returns the text of the current token, or "" at the end of input.
+func (p *Parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+// position returns the byte offset of the current token, or the length of
+// the expression at the end of input.
+func (p *Parser) position() int {
+	if p.pos >= len(p.tokens) {
+		return len(p.expression)
 	}
-	return p.parseExpression()
+	return p.tokens[p.pos].pos
 }
 
 func (p *Parser) parseExpression() (ASTNode, error) {
@@ -65,8 +150,8 @@ func (p *Parser) parseExpression() (ASTNode, error) {
 		return nil, err
 	}
 
-	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "+" || p.tokens[p.pos] == "-") {
-		op := p.tokens[p.pos]
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.peek()
 		p.pos++
 		right, err := p.parseTerm()
 		if err != nil {
@@ -79,15 +164,15 @@ func (p *Parser) parseExpression() (ASTNode, error) {
 }
 
 func (p *Parser) parseTerm() (ASTNode, error) {
-	left, err := p.parseFactor()
+	left, err := p.parseUnary()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "*" || p.tokens[p.pos] == "/") {
-		op := p.tokens[p.pos]
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "//" || p.peek() == "%" {
+		op := p.peek()
 		p.pos++
-		right, err := p.parseFactor()
+		right, err := p.parseUnary()
 		if err != nil {
 			return nil, err
 		}
@@ -97,32 +182,127 @@ func (p *Parser) parseTerm() (ASTNode, error) {
 	return left, nil
 }
 
+func (p *Parser) parseUnary() (ASTNode, error) {
+	if p.peek() == "-" || p.peek() == "+" {
+		op := p.peek()
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return operand, nil
+		}
+		return &UnaryOpNode{Op: op, Operand: operand}, nil
+	}
+
+	return p.parsePower()
+}
+
+// parsePower parses exponentiation, which binds tighter than unary minus on
+// its left and is right-associative: 2^3^2 is 2^(3^2) and -2^2 is -(2^2).
+func (p *Parser) parsePower() (ASTNode, error) {
+	base, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() == "^" {
+		op := p.peek()
+		p.pos++
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryOpNode{Left: base, Op: op, Right: exponent}, nil
+	}
+
+	return base, nil
+}
+
 func (p *Parser) parseFactor() (ASTNode, error) {
 	if p.pos >= len(p.tokens) {
-		return nil, fmt.Errorf("unexpected end of expression")
+		return nil, newParseError(p.expression, p.position(), "unexpected end of expression", expectedOperand...)
 	}
 
-	token := p.tokens[p.pos]
+	tok := p.tokens[p.pos]
 	p.pos++
 
-	if token == "(" {
+	if tok.text == "(" {
 		expr, err := p.parseExpression()
 		if err != nil {
 			return nil, err
 		}
-		
-		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
-			return nil, fmt.Errorf("missing closing parenthesis")
+
+		if p.peek() != ")" {
+			expected := append(append([]string{}, expectedOperators...), ")")
+			return nil, newParseError(p.expression, p.position(), "missing closing parenthesis", expected...)
 		}
-		
+
 		p.pos++ // Consume ")"
 		return expr, nil
 	}
 
-	num, err := strconv.ParseFloat(token, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid token: %s", token)
+	if isIdentifier(tok.text) {
+		if p.peek() == "(" {
+			return p.parseFunctionCall(tok)
+		}
+		return &VariableNode{Name: tok.text}, nil
+	}
+
+	// Literals out of the float64 range are kept, as they may be in range of
+	// the expression's precision, which decides when converting the AST.
+	num, err := strconv.ParseFloat(tok.text, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, newParseError(p.expression, tok.pos, fmt.Sprintf("invalid token: %s", tok.text), expectedOperand...)
 	}
 
-	return &NumberNode{Value: num}, nil
+	return &NumberNode{Value: num, Literal: tok.text}, nil
+}
+
+// parseFunctionCall parses the parenthesized, comma-separated arguments of a
+// call to the named built-in function.
+func (p *Parser) parseFunctionCall(name token) (ASTNode, error) {
+	function, ok := operations.LookupFunction(name.text)
+	if !ok {
+		return nil, newParseError(p.expression, name.pos, fmt.Sprintf("unknown function: %s", name.text))
+	}
+
+	p.pos++ // Consume "("
+
+	var args []ASTNode
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.peek() == "," {
+			p.pos++
+			continue
+		}
+		break
+	}
+
+	if p.peek() != ")" {
+		expected := append(append([]string{}, expectedOperators...), ",", ")")
+		return nil, newParseError(p.expression, p.position(), "missing closing parenthesis", expected...)
+	}
+	p.pos++ // Consume ")"
+
+	if !function.AcceptsArgs(len(args)) {
+		return nil, newParseError(p.expression, name.pos, fmt.Sprintf("function %s does not accept %d arguments", name.text, len(args)))
+	}
+
+	return &FunctionCallNode{Name: name.text, Args: args}, nil
+}
+
+func isIdentifier(s string) bool {
+	for i, char := range s {
+		if !(unicode.IsLetter(char) || char == '_' || (i > 0 && unicode.IsDigit(char))) {
+			return false
+		}
+	}
+	return s != ""
 }
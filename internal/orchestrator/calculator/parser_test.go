@@ -0,0 +1,147 @@
+package calculator
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// parseTest is an expression and its fully parenthesized form, or the
+// position of the syntax error it is rejected with if want is empty.
+type parseTest struct {
+	expression string
+	want       string
+	errorAt    int
+}
+
+func runParseTests(t *testing.T, tests []parseTest) {
+	t.Helper()
+	for _, tt := range tests {
+		ast, err := NewParser(tt.expression).Parse()
+		if tt.want == "" {
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("Parse(%q) = %v, %v, want a *ParseError", tt.expression, ast, err)
+			} else if parseErr.Position != tt.errorAt {
+				t.Errorf("Parse(%q): error %q at position %d, want %d", tt.expression, parseErr.Message, parseErr.Position, tt.errorAt)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expression, err)
+		} else if got := ast.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestParseUnary(t *testing.T) {
+	runParseTests(t, []parseTest{
+		{expression: "-2", want: "(-2)"},
+		{expression: "--2", want: "(-(-2))"},
+		{expression: "+2", want: "2"},
+		{expression: "+-2", want: "(-2)"},
+		{expression: "-+2", want: "(-2)"},
+		{expression: "2--3", want: "(2 - (-3))"},
+		{expression: "2*-3", want: "(2 * (-3))"},
+		{expression: "-(1+2)", want: "(-(1 + 2))"},
+		{expression: "-x", want: "(-x)"},
+		// Signs after an exponent marker belong to the literal, which is
+		// kept as written.
+		{expression: "1e-3", want: "1e-3"},
+		{expression: "1E+3", want: "1E+3"},
+		{expression: "2.5e-2*2", want: "(2.5e-2 * 2)"},
+		{expression: "1e-3-1", want: "(1e-3 - 1)"},
+		{expression: "2-1e+3", want: "(2 - 1e+3)"},
+		{expression: "-", errorAt: 1},
+		{expression: "--", errorAt: 2},
+		{expression: "2 * -", errorAt: 5},
+		{expression: "1e", errorAt: 0},
+	})
+}
+
+func TestParsePrecedence(t *testing.T) {
+	runParseTests(t, []parseTest{
+		{expression: "1 + 2 * 3", want: "(1 + (2 * 3))"},
+		{expression: "(1 + 2) * 3", want: "((1 + 2) * 3)"},
+		{expression: "1 - 2 - 3", want: "((1 - 2) - 3)"},
+		{expression: "8 / 4 / 2", want: "((8 / 4) / 2)"},
+		{expression: "7 // 2 * 3", want: "((7 // 2) * 3)"},
+		{expression: "1 + 7 % 4", want: "(1 + (7 % 4))"},
+		{expression: "2 * 3 ^ 2", want: "(2 * (3 ^ 2))"},
+		// Exponentiation is right-associative and binds tighter than unary
+		// minus on its left, but not on its right.
+		{expression: "2^3^2", want: "(2 ^ (3 ^ 2))"},
+		{expression: "2**3**2", want: "(2 ^ (3 ^ 2))"},
+		{expression: "-2^2", want: "(-(2 ^ 2))"},
+		{expression: "2^-1", want: "(2 ^ (-1))"},
+		{expression: "-2 ^ -2 ^ 2", want: "(-(2 ^ (-(2 ^ 2))))"},
+		{expression: "-max(1,2)^2", want: "(-(max(1, 2) ^ 2))"},
+		{expression: "max(1, 2) + 3", want: "(max(1, 2) + 3)"},
+		{expression: "2 ^", errorAt: 3},
+		{expression: "2 ^ * 3", errorAt: 4},
+	})
+}
+
+func TestFindUnboundVariables(t *testing.T) {
+	variables := map[string]float64{"x": 1, "rate_2": 0.5}
+	tests := []struct {
+		expression string
+		want       []string
+	}{
+		{"x * rate_2", nil},
+		{"-x + max(x, 1)", nil},
+		{"x + y", []string{"y"}},
+		{"b + a * b", []string{"a", "b"}},
+		{"max(x, z) ^ -y", []string{"y", "z"}},
+		{"x2 + _x", []string{"_x", "x2"}},
+		{"длина * 2", []string{"длина"}},
+	}
+
+	for _, tt := range tests {
+		ast, err := NewParser(tt.expression).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expression, err)
+		}
+		got := findUnboundVariables(ast, variables)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("findUnboundVariables(%q) = %q, want %q", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		message    string
+		position   int
+		snippet    string
+	}{
+		{"", "empty expression", 0, "\n^"},
+		{"2 +", "unexpected end of expression", 3, "2 +\n   ^"},
+		{"2 + * 3", "invalid token: *", 4, "2 + * 3\n    ^"},
+		{"(1 + 2", "missing closing parenthesis", 6, "(1 + 2\n      ^"},
+		{"1 + 2)", "unexpected token: )", 5, "1 + 2)\n     ^"},
+		{"2 3", "unexpected token: 3", 2, "2 3\n  ^"},
+		{"2 * (3 + )", "invalid token: )", 9, "2 * (3 + )\n         ^"},
+		{"foo(1)", "unknown function: foo", 0, "foo(1)\n^"},
+		{"max(1 2)", "missing closing parenthesis", 6, "max(1 2)\n      ^"},
+		// Positions are byte offsets, the caret is placed by runes.
+		{"é + * 2", "invalid token: *", 5, "é + * 2\n    ^"},
+	}
+
+	for _, tt := range tests {
+		_, err := NewParser(tt.expression).Parse()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) error = %v, want a *ParseError", tt.expression, err)
+			continue
+		}
+		if parseErr.Message != tt.message || parseErr.Position != tt.position {
+			t.Errorf("Parse(%q) error = %q at %d, want %q at %d", tt.expression, parseErr.Message, parseErr.Position, tt.message, tt.position)
+		}
+		if parseErr.Snippet != tt.snippet {
+			t.Errorf("Parse(%q) snippet:\n%s\nwant:\n%s", tt.expression, parseErr.Snippet, tt.snippet)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AgentStatus string
+
+const (
+	AgentStatusAlive AgentStatus = "ALIVE"
+	// AgentStatusDraining marks an agent told to stop taking work. It
+	// finishes the tasks it holds and then shuts down.
+	AgentStatusDraining AgentStatus = "DRAINING"
+	// AgentStatusDead marks an agent that missed its heartbeats. Its tasks
+	// are re-queued and it must register again to receive more.
+	AgentStatusDead AgentStatus = "DEAD"
+)
+
+type Agent struct {
+	ID          uuid.UUID       `json:"id"`
+	Hostname    string          `json:"hostname"`
+	Version     string          `json:"version"`
+	WorkerCount int             `json:"worker_count"`
+	Operations  []OperationType `json:"operations"`
+	Status      AgentStatus     `json:"status"`
+	// InFlightTasks counts the tasks currently leased to the agent;
+	// CompletedTasks and FailedTasks count the results it reported.
+	InFlightTasks  int       `json:"in_flight_tasks"`
+	CompletedTasks int       `json:"completed_tasks"`
+	FailedTasks    int       `json:"failed_tasks"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+}
+
+type RegisterAgentRequest struct {
+	ID          uuid.UUID       `json:"id"`
+	Hostname    string          `json:"hostname"`
+	Version     string          `json:"version"`
+	WorkerCount int             `json:"worker_count"`
+	Operations  []OperationType `json:"operations"`
+}
+
+type AgentResponse struct {
+	Agent *Agent `json:"agent"`
+}
+
+type AgentsResponse struct {
+	Agents []*Agent `json:"agents"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "fmt"
+
+// TaskErrorCode is the shared vocabulary of operation failures used by both
+// the orchestrator and the agents.
+type TaskErrorCode string
+
+const (
+	ErrorCodeDivisionByZero   TaskErrorCode = "DIVISION_BY_ZERO"
+	ErrorCodeUnknownOperation TaskErrorCode = "UNKNOWN_OPERATION"
+	ErrorCodeDomain           TaskErrorCode = "DOMAIN_ERROR"
+	ErrorCodeInvalidArguments TaskErrorCode = "INVALID_ARGUMENTS"
+	ErrorCodeExecutionFailed  TaskErrorCode = "EXECUTION_FAILED"
+	// ErrorCodeUnsupportedPrecision reports an operation that has no exact
+	// counterpart, such as sqrt in the rational precision.
+	ErrorCodeUnsupportedPrecision TaskErrorCode = "UNSUPPORTED_PRECISION"
+	// ErrorCodeNonFinite reports a result that overflowed to ±Inf or is NaN,
+	// under the NonFiniteError policy.
+	ErrorCodeNonFinite TaskErrorCode = "NON_FINITE_RESULT"
+	// ErrorCodeOverflow reports an integer result too large to represent: out
+	// of the int64 range in the integer precision, or beyond the size limit
+	// of powers in the bigint one.
+	ErrorCodeOverflow TaskErrorCode = "INTEGER_OVERFLOW"
+)
+
+type TaskError struct {
+	Code    TaskErrorCode `json:"code"`
+	Message string        `json:"message"`
+}
+
+func NewTaskError(code TaskErrorCode, format string, args ...interface{}) *TaskError {
+	return &TaskError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *TaskError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType tells what changed: an expression's status, or one of its
+// tasks.
+type EventType string
+
+const (
+	EventExpression EventType = "expression"
+	EventTask       EventType = "task"
+)
+
+// Event reports a change to an expression or one of its tasks, along with
+// the state of the expression after the change.
+type Event struct {
+	// ID numbers the events published since the orchestrator started.
+	ID           uint64           `json:"id,omitempty"`
+	Type         EventType        `json:"type"`
+	ExpressionID uuid.UUID        `json:"expression_id"`
+	Status       ExpressionStatus `json:"status"`
+	Progress     Progress         `json:"progress"`
+	Result       *Float           `json:"result,omitempty"`
+	ExactResult  *string          `json:"exact_result,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	ErrorCode    TaskErrorCode    `json:"error_code,omitempty"`
+	// Task is the task that changed, for task events.
+	Task *TaskEvent `json:"task,omitempty"`
+	Time time.Time  `json:"time"`
+}
+
+// Progress counts the operations of an expression, leaving out the values
+// it was written with.
+type Progress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+// TaskEvent is the state of a task after a change.
+type TaskEvent struct {
+	ID          uuid.UUID     `json:"id"`
+	Operation   OperationType `json:"operation"`
+	Status      TaskStatus    `json:"status"`
+	AgentID     *uuid.UUID    `json:"agent_id,omitempty"`
+	Result      *Float        `json:"result,omitempty"`
+	ExactResult *string       `json:"exact_result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ErrorCode   TaskErrorCode `json:"error_code,omitempty"`
+}
+
+// EventFilter selects the events of a subscription: those of one
+// expression, if ExpressionID is set, and of expressions in any of
+// Statuses, if set.
+type EventFilter struct {
+	ExpressionID uuid.UUID
+	Statuses     []ExpressionStatus
+}
+
+// Matches reports whether the event passes the filter.
+func (f EventFilter) Matches(event Event) bool {
+	if f.ExpressionID != uuid.Nil && event.ExpressionID != f.ExpressionID {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if event.Status == status {
+			return true
+		}
+	}
+	return false
+}
@@ -13,27 +13,69 @@ const (
 	StatusComputing ExpressionStatus = "COMPUTING"
 	StatusCompleted ExpressionStatus = "COMPLETED"
 	StatusError     ExpressionStatus = "ERROR"
+	StatusCancelled ExpressionStatus = "CANCELLED"
 )
 
+// IsFinal reports whether the expression can no longer change status.
+func (s ExpressionStatus) IsFinal() bool {
+	return s == StatusCompleted || s == StatusError || s == StatusCancelled
+}
+
 type Expression struct {
-	ID         uuid.UUID        `json:"id"`
-	Expression string           `json:"expression"`
-	Status     ExpressionStatus `json:"status"`
-	Result     *float64         `json:"result,omitempty"`
-	Error      string           `json:"error,omitempty"`
-	CreatedAt  time.Time        `json:"created_at"`
-	UpdatedAt  time.Time        `json:"updated_at"`
-	Tasks      []*Task          `json:"-"`
+	ID         uuid.UUID          `json:"id"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Precision  Precision          `json:"precision"`
+	Status     ExpressionStatus   `json:"status"`
+	Result     *Float             `json:"result,omitempty"`
+	// ExactResult is the lossless result in the math/big precisions.
+	ExactResult *string       `json:"exact_result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ErrorCode   TaskErrorCode `json:"error_code,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	// Webhook is set for expressions submitted with a callback URL.
+	Webhook *Webhook `json:"webhook,omitempty"`
+	Tasks   []*Task  `json:"-"`
 }
 
 type ExpressionResponse struct {
-	ID     uuid.UUID        `json:"id"`
-	Status ExpressionStatus `json:"status"`
-	Result *float64         `json:"result,omitempty"`
+	ID        uuid.UUID        `json:"id"`
+	Status    ExpressionStatus `json:"status"`
+	Precision PrecisionMode    `json:"precision,omitempty"`
+	// Scale is set for the decimal precision, and Bits and Rounding for the
+	// bigfloat one, along with Digits if the precision was requested in
+	// decimal digits.
+	Scale    *int         `json:"scale,omitempty"`
+	Bits     *uint        `json:"bits,omitempty"`
+	Digits   *int         `json:"digits,omitempty"`
+	Rounding RoundingMode `json:"rounding,omitempty"`
+	Result   *Float       `json:"result,omitempty"`
+	// ExactResult is the lossless result in the math/big precisions: a
+	// fraction such as "1/3" in the rational precision, a decimal with Scale
+	// places in the decimal one and the shortest decimal that identifies
+	// the binary value in the bigfloat one.
+	ExactResult *string       `json:"exact_result,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ErrorCode   TaskErrorCode `json:"error_code,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	// Expression, Canonical and Variables are set in the detail view only.
+	// Canonical is the expression fully parenthesized as it was parsed.
+	Expression string             `json:"expression,omitempty"`
+	Canonical  string             `json:"canonical,omitempty"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Webhook    *Webhook           `json:"webhook,omitempty"`
+	// Tasks is the task DAG, set in the detail view with ?include=tasks.
+	Tasks []TaskDetail `json:"tasks,omitempty"`
 }
 
+// ExpressionsResponse is a page of the expression list. Total counts the
+// expressions on all pages, and NextCursor, when set, fetches the next page.
 type ExpressionsResponse struct {
 	Expressions []ExpressionResponse `json:"expressions"`
+	Total       int                  `json:"total"`
+	NextCursor  string               `json:"next_cursor,omitempty"`
 }
 
 type ExpressionDetailResponse struct {
@@ -41,7 +83,12 @@ type ExpressionDetailResponse struct {
 }
 
 type CalculateRequest struct {
-	Expression string `json:"expression"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	PrecisionRequest
+	// CallbackURL, if set, receives the result once the expression has
+	// finished.
+	CallbackURL string `json:"callback_url,omitempty"`
 }
 
 type CalculateResponse struct {
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Float is a float64 that encodes the non-finite values JSON numbers cannot
+// represent as the JSON strings "+Inf", "-Inf" and "NaN".
+type Float float64
+
+// IsFinite reports whether f is neither infinite nor NaN.
+func (f Float) IsFinite() bool {
+	return !math.IsInf(float64(f), 0) && !math.IsNaN(float64(f))
+}
+
+func (f Float) MarshalJSON() ([]byte, error) {
+	if !f.IsFinite() {
+		return []byte(strconv.Quote(strconv.FormatFloat(float64(f), 'g', -1, 64))), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (f *Float) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		switch s {
+		case "+Inf":
+			*f = Float(math.Inf(1))
+		case "-Inf":
+			*f = Float(math.Inf(-1))
+		case "NaN":
+			*f = Float(math.NaN())
+		default:
+			return fmt.Errorf("invalid number %q", s)
+		}
+		return nil
+	}
+
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = Float(v)
+	return nil
+}
+
+// Floats converts float64 values to Float.
+func Floats(values []float64) []Float {
+	if values == nil {
+		return nil
+	}
+	result := make([]Float, len(values))
+	for i, v := range values {
+		result[i] = Float(v)
+	}
+	return result
+}
+
+// Float64s converts Float values to float64.
+func Float64s(values []Float) []float64 {
+	if values == nil {
+		return nil
+	}
+	result := make([]float64, len(values))
+	for i, v := range values {
+		result[i] = float64(v)
+	}
+	return result
+}
+
+// NonFinitePolicy decides what happens to results that overflow to ±Inf or
+// are NaN.
+type NonFinitePolicy string
+
+const (
+	// NonFiniteError fails the task, and with it the expression, with
+	// ErrorCodeNonFinite.
+	NonFiniteError NonFinitePolicy = "error"
+	// NonFiniteString keeps the value, which the API encodes as a string.
+	NonFiniteString NonFinitePolicy = "string"
+)
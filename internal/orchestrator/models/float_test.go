@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestFloatJSON(t *testing.T) {
+	tests := []struct {
+		value Float
+		json  string
+	}{
+		{1.5, `1.5`},
+		{-2, `-2`},
+		{Float(math.Inf(1)), `"+Inf"`},
+		{Float(math.Inf(-1)), `"-Inf"`},
+		{Float(math.NaN()), `"NaN"`},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.value)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", tt.value, err)
+		}
+		if string(data) != tt.json {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.value, data, tt.json)
+		}
+
+		var got Float
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		if got != tt.value && !(math.IsNaN(float64(got)) && math.IsNaN(float64(tt.value))) {
+			t.Errorf("Unmarshal(%s) = %v, want %v", data, got, tt.value)
+		}
+	}
+
+	var f Float
+	if err := json.Unmarshal([]byte(`"Infinity"`), &f); err == nil {
+		t.Errorf(`Unmarshal("Infinity") = %v, want an error`, f)
+	}
+}
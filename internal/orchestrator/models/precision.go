@@ -0,0 +1,158 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PrecisionMode selects the arithmetic an expression is evaluated with.
+type PrecisionMode string
+
+const (
+	PrecisionFloat64 PrecisionMode = "float64"
+	// PrecisionRational evaluates exactly, with arbitrary-precision
+	// fractions.
+	PrecisionRational PrecisionMode = "rational"
+	// PrecisionDecimal evaluates like PrecisionRational but rounds literals
+	// and every intermediate result to Scale decimal places, half away from
+	// zero.
+	PrecisionDecimal PrecisionMode = "decimal"
+	// PrecisionBigFloat evaluates with binary floating point of Bits
+	// mantissa bits, rounding literals and every intermediate result with
+	// Rounding.
+	PrecisionBigFloat PrecisionMode = "bigfloat"
+	// PrecisionInteger evaluates whole numbers in int64: literals must be
+	// integers, division must be exact and overflow is an error.
+	// PrecisionBigInt does the same without bounds.
+	PrecisionInteger PrecisionMode = "integer"
+	PrecisionBigInt  PrecisionMode = "bigint"
+)
+
+// RoundingMode is the rounding of the bigfloat precision, one of the modes
+// of math/big.
+type RoundingMode string
+
+const (
+	RoundingNearestEven   RoundingMode = "nearest_even"
+	RoundingNearestAway   RoundingMode = "nearest_away"
+	RoundingToZero        RoundingMode = "to_zero"
+	RoundingAwayFromZero  RoundingMode = "away_from_zero"
+	RoundingToNegativeInf RoundingMode = "to_negative_inf"
+	RoundingToPositiveInf RoundingMode = "to_positive_inf"
+)
+
+const (
+	DefaultDecimalScale = 2
+	MaxDecimalScale     = 100
+
+	DefaultBigFloatBits = 256
+	MaxBigFloatBits     = 1 << 16
+	MaxBigFloatDigits   = 10000
+)
+
+// ErrInvalidPrecision is returned for unknown precision modes and invalid
+// precision parameters.
+var ErrInvalidPrecision = errors.New("invalid precision")
+
+// Precision is the arithmetic of an expression and of each of its tasks.
+// The zero value stands for float64.
+type Precision struct {
+	Mode  PrecisionMode `json:"mode"`
+	Scale int           `json:"scale,omitempty"`
+	// Bits is the mantissa size of the bigfloat precision, and Digits the
+	// number of significant decimal digits it was requested as, if it was.
+	Bits     uint         `json:"bits,omitempty"`
+	Digits   int          `json:"digits,omitempty"`
+	Rounding RoundingMode `json:"rounding,omitempty"`
+}
+
+// PrecisionRequest holds the precision parameters of a calculation
+// request. Scale applies to the decimal precision; Bits or Digits, and
+// Rounding, to the bigfloat one.
+type PrecisionRequest struct {
+	Precision PrecisionMode `json:"precision,omitempty"`
+	Scale     *int          `json:"scale,omitempty"`
+	Bits      *int          `json:"bits,omitempty"`
+	Digits    *int          `json:"digits,omitempty"`
+	Rounding  RoundingMode  `json:"rounding,omitempty"`
+}
+
+// NewPrecision validates the precision parameters of a request, filling in
+// defaults. The mode is float64 when omitted.
+func NewPrecision(req PrecisionRequest) (Precision, error) {
+	mode := req.Precision
+	if mode == "" {
+		mode = PrecisionFloat64
+	}
+	if req.Scale != nil && mode != PrecisionDecimal {
+		return Precision{}, fmt.Errorf("%w: scale applies to the decimal precision only", ErrInvalidPrecision)
+	}
+	if (req.Bits != nil || req.Digits != nil || req.Rounding != "") && mode != PrecisionBigFloat {
+		return Precision{}, fmt.Errorf("%w: bits, digits and rounding apply to the bigfloat precision only", ErrInvalidPrecision)
+	}
+
+	switch mode {
+	case PrecisionFloat64, PrecisionRational, PrecisionInteger, PrecisionBigInt:
+		return Precision{Mode: mode}, nil
+	case PrecisionDecimal:
+		precision := Precision{Mode: mode, Scale: DefaultDecimalScale}
+		if req.Scale != nil {
+			if *req.Scale < 0 || *req.Scale > MaxDecimalScale {
+				return Precision{}, fmt.Errorf("%w: scale must be between 0 and %d", ErrInvalidPrecision, MaxDecimalScale)
+			}
+			precision.Scale = *req.Scale
+		}
+		return precision, nil
+	case PrecisionBigFloat:
+		return newBigFloatPrecision(req)
+	default:
+		return Precision{}, fmt.Errorf("%w: unknown precision %q", ErrInvalidPrecision, mode)
+	}
+}
+
+func newBigFloatPrecision(req PrecisionRequest) (Precision, error) {
+	precision := Precision{Mode: PrecisionBigFloat, Bits: DefaultBigFloatBits, Rounding: RoundingNearestEven}
+
+	switch {
+	case req.Bits != nil && req.Digits != nil:
+		return Precision{}, fmt.Errorf("%w: bits and digits are mutually exclusive", ErrInvalidPrecision)
+	case req.Bits != nil:
+		if *req.Bits < 1 || *req.Bits > MaxBigFloatBits {
+			return Precision{}, fmt.Errorf("%w: bits must be between 1 and %d", ErrInvalidPrecision, MaxBigFloatBits)
+		}
+		precision.Bits = uint(*req.Bits)
+	case req.Digits != nil:
+		if *req.Digits < 1 || *req.Digits > MaxBigFloatDigits {
+			return Precision{}, fmt.Errorf("%w: digits must be between 1 and %d", ErrInvalidPrecision, MaxBigFloatDigits)
+		}
+		precision.Digits = *req.Digits
+		precision.Bits = uint(math.Ceil(float64(*req.Digits) * math.Log2(10)))
+	}
+
+	switch req.Rounding {
+	case "":
+	case RoundingNearestEven, RoundingNearestAway, RoundingToZero, RoundingAwayFromZero,
+		RoundingToNegativeInf, RoundingToPositiveInf:
+		precision.Rounding = req.Rounding
+	default:
+		return Precision{}, fmt.Errorf("%w: unknown rounding %q", ErrInvalidPrecision, req.Rounding)
+	}
+	return precision, nil
+}
+
+// IsExact reports whether the precision evaluates exactly, with fractions.
+func (p Precision) IsExact() bool {
+	return p.Mode == PrecisionRational || p.Mode == PrecisionDecimal
+}
+
+// IsInteger reports whether the precision evaluates whole numbers.
+func (p Precision) IsInteger() bool {
+	return p.Mode == PrecisionInteger || p.Mode == PrecisionBigInt
+}
+
+// IsBig reports whether values are evaluated with math/big and carried
+// losslessly as strings rather than as float64.
+func (p Precision) IsBig() bool {
+	return p.IsExact() || p.IsInteger() || p.Mode == PrecisionBigFloat
+}
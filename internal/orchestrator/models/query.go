@@ -0,0 +1,117 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExpressionSort is the timestamp an expression list is ordered by. Ties
+// are broken by ID, so the order is total.
+type ExpressionSort string
+
+const (
+	SortCreatedAt ExpressionSort = "created_at"
+	SortUpdatedAt ExpressionSort = "updated_at"
+)
+
+const (
+	DefaultExpressionPageSize = 50
+	MaxExpressionPageSize     = 500
+)
+
+// ErrInvalidCursor is returned for cursors that were not issued by
+// ExpressionCursor.String.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ExpressionQuery selects a page of expressions.
+type ExpressionQuery struct {
+	// Statuses keeps the expressions in any of the statuses; empty keeps
+	// all of them.
+	Statuses []ExpressionStatus
+	// CreatedFrom and CreatedTo bound the creation time, inclusive and
+	// exclusive respectively. Zero values leave that side open.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        ExpressionSort
+	Descending  bool
+	// After resumes the list past the last expression of a previous page.
+	After *ExpressionCursor
+	Limit int
+}
+
+// Matches reports whether the expression passes the status and creation
+// time filters of the query.
+func (q ExpressionQuery) Matches(expr *Expression) bool {
+	created := expr.CreatedAt.UnixNano()
+	if !q.CreatedFrom.IsZero() && created < q.CreatedFrom.UnixNano() {
+		return false
+	}
+	if !q.CreatedTo.IsZero() && created >= q.CreatedTo.UnixNano() {
+		return false
+	}
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	for _, status := range q.Statuses {
+		if expr.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpressionCursor is the position of an expression in a sorted list: its
+// sort timestamp in Unix nanoseconds and its ID.
+type ExpressionCursor struct {
+	Key int64
+	ID  uuid.UUID
+}
+
+// CursorFor returns the position of the expression in a list sorted by sort.
+func CursorFor(expr *Expression, sort ExpressionSort) ExpressionCursor {
+	key := expr.CreatedAt
+	if sort == SortUpdatedAt {
+		key = expr.UpdatedAt
+	}
+	return ExpressionCursor{Key: key.UnixNano(), ID: expr.ID}
+}
+
+// String encodes the cursor as an opaque URL-safe token.
+func (c ExpressionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Key, 10) + ":" + c.ID.String()))
+}
+
+// ParseExpressionCursor decodes a token returned by ExpressionCursor.String.
+func ParseExpressionCursor(s string) (ExpressionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ExpressionCursor{}, ErrInvalidCursor
+	}
+	keyStr, idStr, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return ExpressionCursor{}, ErrInvalidCursor
+	}
+	key, err := strconv.ParseInt(keyStr, 10, 64)
+	if err != nil {
+		return ExpressionCursor{}, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return ExpressionCursor{}, ErrInvalidCursor
+	}
+	return ExpressionCursor{Key: key, ID: id}, nil
+}
+
+// ExpressionPage is one page of an expression list. Total counts every
+// expression passing the filters, on all pages, and Next is set when there
+// are more pages.
+type ExpressionPage struct {
+	Expressions []*Expression
+	Total       int
+	Next        *ExpressionCursor
+}
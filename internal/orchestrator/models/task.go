@@ -9,10 +9,11 @@ import (
 type TaskStatus string
 
 const (
-	TaskStatusPending   TaskStatus = "PENDING"
+	TaskStatusPending    TaskStatus = "PENDING"
 	TaskStatusProcessing TaskStatus = "PROCESSING"
 	TaskStatusCompleted  TaskStatus = "COMPLETED"
 	TaskStatusError      TaskStatus = "ERROR"
+	TaskStatusCancelled  TaskStatus = "CANCELLED"
 )
 
 type OperationType string
@@ -22,40 +23,164 @@ const (
 	OperationSubtraction    OperationType = "SUBTRACTION"
 	OperationMultiplication OperationType = "MULTIPLICATION"
 	OperationDivision       OperationType = "DIVISION"
-	OperationValue          OperationType = "VALUE" // Just a value, no operation
+	OperationNegation       OperationType = "NEGATION"
+	OperationPower          OperationType = "POWER"
+	// OperationFloorDivision and OperationModulo are // and %: the quotient
+	// rounded down and the remainder, which has the sign of the divisor.
+	OperationFloorDivision OperationType = "FLOOR_DIVISION"
+	OperationModulo        OperationType = "MODULO"
+	OperationSqrt          OperationType = "SQRT"
+	OperationAbs           OperationType = "ABS"
+	OperationLn            OperationType = "LN"
+	OperationLog           OperationType = "LOG"
+	OperationSin           OperationType = "SIN"
+	OperationCos           OperationType = "COS"
+	OperationTan           OperationType = "TAN"
+	OperationMin           OperationType = "MIN"
+	OperationMax           OperationType = "MAX"
+	OperationRound         OperationType = "ROUND"
+	OperationValue         OperationType = "VALUE" // Just a value, no operation
 )
 
 type Task struct {
 	ID            uuid.UUID     `json:"id"`
 	ExpressionID  uuid.UUID     `json:"-"`
-	Arg1          *Task         `json:"-"`
-	Arg1Value     float64       `json:"arg1"`
-	Arg2          *Task         `json:"-"`  
-	Arg2Value     float64       `json:"arg2"`
+	Args          []Float       `json:"args"`
 	Operation     OperationType `json:"operation"`
 	OperationTime int           `json:"operation_time"`
 	Status        TaskStatus    `json:"status"`
-	Result        *float64      `json:"result,omitempty"`
+	Result        *Float        `json:"result,omitempty"`
 	Error         string        `json:"error,omitempty"`
-	Dependencies  []*uuid.UUID  `json:"-"`
-	CreatedAt     time.Time     `json:"-"`
-	StartedAt     *time.Time    `json:"-"`
-	CompletedAt   *time.Time    `json:"-"`
+	ErrorCode     TaskErrorCode `json:"error_code,omitempty"`
+	// Dependencies holds the task producing each argument, in argument order.
+	Dependencies []*uuid.UUID `json:"-"`
+	// Dependents lists the tasks that consume this task's result, and
+	// PendingDependencies counts the dependencies that are not completed yet.
+	Dependents          []uuid.UUID `json:"-"`
+	PendingDependencies int         `json:"-"`
+	CreatedAt           time.Time   `json:"-"`
+	StartedAt           *time.Time  `json:"-"`
+	CompletedAt         *time.Time  `json:"-"`
+	// LeaseToken identifies the current holder of a PROCESSING task. It is
+	// regenerated on every hand-out so that a stale holder cannot submit.
+	LeaseToken     uuid.UUID  `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
+	// AgentID is the registered agent the task was last leased to, or
+	// uuid.Nil if it was never leased or went to an unregistered agent.
+	AgentID uuid.UUID `json:"-"`
+	// Precision is the arithmetic of the task's expression. In the math/big
+	// precisions ExactArgs and ExactResult carry the values losslessly,
+	// while Args and Result hold their float64 approximations.
+	Precision   Precision `json:"-"`
+	ExactArgs   []string  `json:"-"`
+	ExactResult *string   `json:"-"`
 }
 
 type TaskResponse struct {
+	ID             uuid.UUID     `json:"id"`
+	Args           []Float       `json:"args"`
+	Operation      OperationType `json:"operation"`
+	OperationTime  int           `json:"operation_time"`
+	LeaseToken     uuid.UUID     `json:"lease_token"`
+	LeaseExpiresAt time.Time     `json:"lease_expires_at"`
+	// Precision and ExactArgs are set for tasks evaluated in a math/big
+	// precision, which must be computed from ExactArgs rather than Args.
+	Precision *Precision `json:"precision,omitempty"`
+	ExactArgs []string   `json:"exact_args,omitempty"`
+}
+
+// TaskDetail is the full state of a task, for inspecting the task DAG of an
+// expression.
+type TaskDetail struct {
 	ID            uuid.UUID     `json:"id"`
-	Arg1          float64       `json:"arg1"`
-	Arg2          float64       `json:"arg2"`
 	Operation     OperationType `json:"operation"`
 	OperationTime int           `json:"operation_time"`
+	Args          []Float       `json:"args"`
+	ExactArgs     []string      `json:"exact_args,omitempty"`
+	// Dependencies lists the tasks producing the arguments, in argument
+	// order, and Dependents the tasks consuming the result.
+	Dependencies        []uuid.UUID   `json:"dependencies"`
+	Dependents          []uuid.UUID   `json:"dependents"`
+	PendingDependencies int           `json:"pending_dependencies"`
+	Status              TaskStatus    `json:"status"`
+	Result              *Float        `json:"result,omitempty"`
+	ExactResult         *string       `json:"exact_result,omitempty"`
+	Error               string        `json:"error,omitempty"`
+	ErrorCode           TaskErrorCode `json:"error_code,omitempty"`
+	// AgentID is the registered agent the task was last leased to.
+	AgentID        *uuid.UUID `json:"agent_id,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
 }
 
 type GetTaskResponse struct {
 	Task *TaskResponse `json:"task,omitempty"`
 }
 
+type GetTasksResponse struct {
+	Tasks []TaskResponse `json:"tasks"`
+}
+
+const (
+	SubmitStatusSuccess = "success"
+	// SubmitStatusCancelled acknowledges a submission for a task whose
+	// expression was cancelled; the result is discarded and must not be
+	// retried.
+	SubmitStatusCancelled = "cancelled"
+	// SubmitStatusConflict, SubmitStatusNotFound and SubmitStatusError
+	// reject an item of a batched submission whose lease expired, whose task
+	// is unknown or that could not be stored.
+	SubmitStatusConflict = "conflict"
+	SubmitStatusNotFound = "not_found"
+	SubmitStatusError    = "error"
+)
+
+// TaskResultRequest reports the outcome of a task. When Error is set the
+// task failed and Result is ignored.
 type TaskResultRequest struct {
+	ID         uuid.UUID `json:"id"`
+	LeaseToken uuid.UUID `json:"lease_token"`
+	Result     Float     `json:"result"`
+	// ExactResult is the lossless result of a task evaluated in a math/big
+	// precision.
+	ExactResult string     `json:"exact_result,omitempty"`
+	Error       *TaskError `json:"error,omitempty"`
+}
+
+type TaskResultResponse struct {
+	Status string `json:"status"`
+}
+
+type TaskResultsRequest struct {
+	Results []TaskResultRequest `json:"results"`
+}
+
+// TaskResultItemResponse is the outcome of one item of a batched
+// submission.
+type TaskResultItemResponse struct {
 	ID     uuid.UUID `json:"id"`
-	Result float64   `json:"result"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type TaskResultsResponse struct {
+	Results []TaskResultItemResponse `json:"results"`
+}
+
+// TaskLease identifies a task leased to the caller.
+type TaskLease struct {
+	ID         uuid.UUID `json:"id"`
+	LeaseToken uuid.UUID `json:"lease_token"`
+}
+
+// TaskReleaseRequest gives leased tasks back without results, so that they
+// are handed to another agent right away.
+type TaskReleaseRequest struct {
+	Tasks []TaskLease `json:"tasks"`
+}
+
+type TaskReleaseResponse struct {
+	Released int `json:"released"`
 }
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebhookStatus is the state of the delivery of an expression's result to
+// its callback URL.
+type WebhookStatus string
+
+const (
+	// WebhookPending is awaiting the end of the expression, or a retry.
+	WebhookPending   WebhookStatus = "PENDING"
+	WebhookDelivered WebhookStatus = "DELIVERED"
+	// WebhookFailed gave up after the last attempt.
+	WebhookFailed WebhookStatus = "FAILED"
+)
+
+// ErrInvalidCallbackURL is returned for callback URLs that are not absolute
+// http or https URLs.
+var ErrInvalidCallbackURL = errors.New("invalid callback_url")
+
+// Webhook is the callback of an expression and its delivery so far.
+type Webhook struct {
+	URL      string           `json:"url"`
+	Status   WebhookStatus    `json:"status"`
+	Attempts []WebhookAttempt `json:"attempts"`
+}
+
+// WebhookAttempt records one POST to a callback URL: the response status,
+// or the error if there was no response.
+type WebhookAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewWebhook validates a callback URL and returns its pending webhook.
+func NewWebhook(callbackURL string) (*Webhook, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: must be an absolute http or https URL", ErrInvalidCallbackURL)
+	}
+	return &Webhook{URL: callbackURL, Status: WebhookPending, Attempts: []WebhookAttempt{}}, nil
+}
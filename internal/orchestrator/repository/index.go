@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// indexEntry places an expression in an ordered index by a timestamp, in
+// Unix nanoseconds, and its ID.
+type indexEntry struct {
+	key int64
+	id  uuid.UUID
+}
+
+func compareEntries(a, b indexEntry) int {
+	switch {
+	case a.key < b.key:
+		return -1
+	case a.key > b.key:
+		return 1
+	default:
+		return bytes.Compare(a.id[:], b.id[:])
+	}
+}
+
+// expressionIndex keeps expressions ordered by a timestamp. Entries are
+// inserted in place, which appends in the common case of a clock moving
+// forward.
+type expressionIndex []indexEntry
+
+func (x *expressionIndex) insert(entry indexEntry) {
+	i := x.search(entry)
+	*x = slices.Insert(*x, i, entry)
+}
+
+// search returns the position of the first entry not before entry.
+func (x expressionIndex) search(entry indexEntry) int {
+	return sort.Search(len(x), func(i int) bool {
+		return compareEntries(x[i], entry) >= 0
+	})
+}
+
+// searchKey returns the position of the first entry at or after key.
+func (x expressionIndex) searchKey(key int64) int {
+	return sort.Search(len(x), func(i int) bool {
+		return x[i].key >= key
+	})
+}
+
+// indexExpression adds a new expression to the ordered indexes.
+func (r *Repository) indexExpression(expr *models.Expression) {
+	r.byCreated.insert(indexEntry{key: expr.CreatedAt.UnixNano(), id: expr.ID})
+	r.byUpdated.insert(indexEntry{key: expr.UpdatedAt.UnixNano(), id: expr.ID})
+}
+
+// touch sets the update time of an expression and moves it in the update
+// index. The old entry is left behind as stale, which is cheaper than
+// removing it from the middle of the index, and stale entries are dropped
+// once they make up half of it.
+func (r *Repository) touch(expr *models.Expression) {
+	previous := expr.UpdatedAt.UnixNano()
+	expr.UpdatedAt = time.Now()
+	if expr.UpdatedAt.UnixNano() == previous {
+		return
+	}
+	r.byUpdated.insert(indexEntry{key: expr.UpdatedAt.UnixNano(), id: expr.ID})
+
+	r.staleUpdated++
+	if r.staleUpdated > len(r.byUpdated)/2 {
+		live := r.byUpdated[:0]
+		for _, entry := range r.byUpdated {
+			if !r.isStale(entry) {
+				live = append(live, entry)
+			}
+		}
+		clear(r.byUpdated[len(live):])
+		r.byUpdated = live
+		r.staleUpdated = 0
+	}
+}
+
+// isStale reports whether an update index entry has been superseded.
+func (r *Repository) isStale(entry indexEntry) bool {
+	return r.expressions[entry.id].UpdatedAt.UnixNano() != entry.key
+}
+
+// ListExpressions returns a page of expressions, walking the index of the
+// sort order from the cursor. The total is read off the creation index: a
+// binary search without a status filter, a scan of the creation time range
+// with one.
+func (r *Repository) ListExpressions(query models.ExpressionQuery) (*models.ExpressionPage, error) {
+	r.expressionMutex.RLock()
+	defer r.expressionMutex.RUnlock()
+
+	from, to := 0, len(r.byCreated)
+	if !query.CreatedFrom.IsZero() {
+		from = r.byCreated.searchKey(query.CreatedFrom.UnixNano())
+	}
+	if !query.CreatedTo.IsZero() {
+		to = max(from, r.byCreated.searchKey(query.CreatedTo.UnixNano()))
+	}
+
+	page := &models.ExpressionPage{Expressions: []*models.Expression{}}
+	if len(query.Statuses) == 0 {
+		page.Total = to - from
+	} else {
+		for _, entry := range r.byCreated[from:to] {
+			if query.Matches(r.expressions[entry.id]) {
+				page.Total++
+			}
+		}
+	}
+
+	// The creation index is already narrowed to the time range; the update
+	// index is walked whole and filtered.
+	index, staleEntries := r.byCreated[from:to], false
+	if query.Sort == models.SortUpdatedAt {
+		index, staleEntries = r.byUpdated, true
+	}
+
+	start, end, step := 0, len(index), 1
+	if query.After != nil {
+		cursor := indexEntry{key: query.After.Key, id: query.After.ID}
+		i := index.search(cursor)
+		if query.Descending {
+			end = i
+		} else {
+			if i < len(index) && compareEntries(index[i], cursor) == 0 {
+				i++
+			}
+			start = i
+		}
+	}
+	if query.Descending {
+		start, end, step = end-1, start-1, -1
+	}
+
+	for i := start; i != end; i += step {
+		entry := index[i]
+		if staleEntries && r.isStale(entry) {
+			continue
+		}
+		expr := r.expressions[entry.id]
+		if !query.Matches(expr) {
+			continue
+		}
+		if len(page.Expressions) == query.Limit {
+			next := models.CursorFor(page.Expressions[len(page.Expressions)-1], query.Sort)
+			page.Next = &next
+			break
+		}
+		page.Expressions = append(page.Expressions, cloneExpression(expr))
+	}
+	return page, nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// saveReadyTask stores an expression made of a single ready task adding 1
+// and 2.
+func saveReadyTask(t *testing.T, store Store) *models.Task {
+	t.Helper()
+	precision := models.Precision{Mode: models.PrecisionFloat64}
+	expr, err := store.CreateExpression(uuid.New(), "1+2", nil, precision, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &models.Task{
+		ID:           uuid.New(),
+		ExpressionID: expr.ID,
+		Args:         []models.Float{1, 2},
+		Operation:    models.OperationAddition,
+		Precision:    precision,
+		Status:       models.TaskStatusPending,
+	}
+	if err := store.SaveTasks([]*models.Task{task}); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// leaseTask leases the only ready task of store.
+func leaseTask(t *testing.T, store Store, leaseSlack time.Duration) *models.Task {
+	t.Helper()
+	tasks, err := store.GetNextPendingTasks(1, uuid.New(), leaseSlack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks[0]
+}
+
+func completeWith(result models.Float) func(task *models.Task) {
+	return func(task *models.Task) {
+		task.Status = models.TaskStatusCompleted
+		task.Result = &result
+	}
+}
+
+func TestRequeueExpiredTasks(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			task := saveReadyTask(t, store)
+			leased := leaseTask(t, store, time.Minute)
+
+			requeued, err := store.RequeueExpiredTasks(time.Now())
+			if err != nil || len(requeued) != 0 {
+				t.Fatalf("requeued %v, %v before the lease expired", requeued, err)
+			}
+			if _, err := store.GetNextPendingTasks(1, uuid.New(), time.Minute); !errors.Is(err, ErrNoTasksAvailable) {
+				t.Fatalf("leased task dispatched again: %v", err)
+			}
+
+			requeued, err = store.RequeueExpiredTasks(time.Now().Add(2 * time.Minute))
+			if err != nil || len(requeued) != 1 || requeued[0] != task.ID {
+				t.Fatalf("requeued %v, %v after the lease expired, want [%s]", requeued, err, task.ID)
+			}
+			stored, err := store.GetTaskByID(task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != models.TaskStatusPending || stored.LeaseToken != uuid.Nil {
+				t.Errorf("requeued task is %s with lease %s, want PENDING without lease", stored.Status, stored.LeaseToken)
+			}
+
+			again := leaseTask(t, store, time.Minute)
+			if again.ID != task.ID || again.LeaseToken == leased.LeaseToken {
+				t.Errorf("leased %s with token %s again, want %s with a new token", again.ID, again.LeaseToken, task.ID)
+			}
+		})
+	}
+}
+
+func TestCompleteTaskStaleLease(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			task := saveReadyTask(t, store)
+
+			if _, err := store.CompleteTask(task.ID, uuid.New(), completeWith(3)); !errors.Is(err, ErrLeaseMismatch) {
+				t.Errorf("completing an unleased task: got %v, want ErrLeaseMismatch", err)
+			}
+
+			leased := leaseTask(t, store, time.Minute)
+			if _, err := store.CompleteTask(task.ID, uuid.New(), completeWith(3)); !errors.Is(err, ErrLeaseMismatch) {
+				t.Errorf("completing with another token: got %v, want ErrLeaseMismatch", err)
+			}
+			if _, err := store.CompleteTask(uuid.New(), leased.LeaseToken, completeWith(3)); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("completing an unknown task: got %v, want ErrTaskNotFound", err)
+			}
+
+			if _, err := store.CompleteTask(task.ID, leased.LeaseToken, completeWith(3)); err != nil {
+				t.Fatalf("completing with the lease token: %v", err)
+			}
+			if _, err := store.CompleteTask(task.ID, leased.LeaseToken, completeWith(3)); !errors.Is(err, ErrLeaseMismatch) {
+				t.Errorf("completing twice: got %v, want ErrLeaseMismatch", err)
+			}
+		})
+	}
+}
+
+// TestCompleteTaskAfterRequeue submits the result of an expired lease after
+// the task went to another agent: only the new lease holder's result counts.
+func TestCompleteTaskAfterRequeue(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			task := saveReadyTask(t, store)
+			expired := leaseTask(t, store, 0)
+			if _, err := store.RequeueExpiredTasks(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.CompleteTask(task.ID, expired.LeaseToken, completeWith(-1)); !errors.Is(err, ErrLeaseMismatch) {
+				t.Errorf("late submit while queued: got %v, want ErrLeaseMismatch", err)
+			}
+
+			current := leaseTask(t, store, time.Minute)
+			if _, err := store.CompleteTask(task.ID, expired.LeaseToken, completeWith(-1)); !errors.Is(err, ErrLeaseMismatch) {
+				t.Errorf("late submit while leased again: got %v, want ErrLeaseMismatch", err)
+			}
+			if _, err := store.CompleteTask(task.ID, current.LeaseToken, completeWith(3)); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := store.GetTaskByID(task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != models.TaskStatusCompleted || stored.Result == nil || *stored.Result != 3 {
+				t.Errorf("task is %s with result %v, want COMPLETED 3", stored.Status, stored.Result)
+			}
+		})
+	}
+}
@@ -2,47 +2,70 @@ package repository
 
 import (
 	"errors"
+	"slices"
 	"sync"
 	"time"
 
-	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 )
 
 var (
 	ErrExpressionNotFound = errors.New("expression not found")
 	ErrTaskNotFound       = errors.New("task not found")
 	ErrNoTasksAvailable   = errors.New("no tasks available")
+	ErrLeaseMismatch      = errors.New("task lease is not held by the caller")
+	ErrTaskCancelled      = errors.New("task was cancelled")
+	ErrExpressionFinished = errors.New("expression has already finished")
+	ErrNoWebhook          = errors.New("expression has no webhook")
 )
 
 type Repository struct {
-	expressions     map[uuid.UUID]*models.Expression
-	tasks           map[uuid.UUID]*models.Task
+	expressions       map[uuid.UUID]*models.Expression
+	tasks             map[uuid.UUID]*models.Task
+	tasksByExpression map[uuid.UUID][]*models.Task
+	// rootTasks holds the task producing each expression's final result.
+	rootTasks map[uuid.UUID]*models.Task
+	// ready is a FIFO of pending tasks whose dependencies are all resolved.
+	ready  []*models.Task
+	leased map[uuid.UUID]*models.Task
+	// byCreated and byUpdated order the expressions for listing; see
+	// index.go.
+	byCreated       expressionIndex
+	byUpdated       expressionIndex
+	staleUpdated    int
 	expressionMutex sync.RWMutex
 	taskMutex       sync.RWMutex
 }
 
 func NewRepository() *Repository {
 	return &Repository{
-		expressions: make(map[uuid.UUID]*models.Expression),
-		tasks:       make(map[uuid.UUID]*models.Task),
+		expressions:       make(map[uuid.UUID]*models.Expression),
+		tasks:             make(map[uuid.UUID]*models.Task),
+		tasksByExpression: make(map[uuid.UUID][]*models.Task),
+		rootTasks:         make(map[uuid.UUID]*models.Task),
+		leased:            make(map[uuid.UUID]*models.Task),
 	}
 }
 
-func (r *Repository) CreateExpression(expression string) (*models.Expression, error) {
+func (r *Repository) CreateExpression(id uuid.UUID, expression string, variables map[string]float64, precision models.Precision, webhook *models.Webhook) (*models.Expression, error) {
 	r.expressionMutex.Lock()
 	defer r.expressionMutex.Unlock()
 
 	expr := &models.Expression{
-		ID:         uuid.New(),
+		ID:         id,
 		Expression: expression,
+		Variables:  variables,
+		Precision:  precision,
 		Status:     models.StatusPending,
+		Webhook:    webhook,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
 
 	r.expressions[expr.ID] = expr
-	return expr, nil
+	r.indexExpression(expr)
+	return cloneExpression(expr), nil
 }
 
 func (r *Repository) GetExpressionByID(id uuid.UUID) (*models.Expression, error) {
@@ -53,18 +76,7 @@ func (r *Repository) GetExpressionByID(id uuid.UUID) (*models.Expression, error)
 	if !exists {
 		return nil, ErrExpressionNotFound
 	}
-	return expr, nil
-}
-
-func (r *Repository) GetAllExpressions() []*models.Expression {
-	r.expressionMutex.RLock()
-	defer r.expressionMutex.RUnlock()
-
-	result := make([]*models.Expression, 0, len(r.expressions))
-	for _, expr := range r.expressions {
-		result = append(result, expr)
-	}
-	return result
+	return cloneExpression(expr), nil
 }
 
 func (r *Repository) UpdateExpression(expr *models.Expression) error {
@@ -75,17 +87,36 @@ func (r *Repository) UpdateExpression(expr *models.Expression) error {
 		return ErrExpressionNotFound
 	}
 
-	expr.UpdatedAt = time.Now()
-	r.expressions[expr.ID] = expr
+	stored := cloneExpression(expr)
+	r.expressions[expr.ID] = stored
+	r.touch(stored)
+	expr.UpdatedAt = stored.UpdatedAt
 	return nil
 }
 
+// cloneExpression copies an expression so that callers can read it while
+// the stored one is being updated. The webhook is replaced rather than
+// modified, so the copy may share it.
+func cloneExpression(expr *models.Expression) *models.Expression {
+	clone := *expr
+	return &clone
+}
+
+// SaveTasks stores the tasks of an expression, indexes them and queues the
+// ones whose dependencies are already resolved.
 func (r *Repository) SaveTasks(tasks []*models.Task) error {
 	r.taskMutex.Lock()
 	defer r.taskMutex.Unlock()
 
 	for _, task := range tasks {
 		r.tasks[task.ID] = task
+		r.tasksByExpression[task.ExpressionID] = append(r.tasksByExpression[task.ExpressionID], task)
+		if len(task.Dependents) == 0 {
+			r.rootTasks[task.ExpressionID] = task
+		}
+		if task.Status == models.TaskStatusPending && task.PendingDependencies == 0 {
+			r.ready = append(r.ready, task)
+		}
 	}
 	return nil
 }
@@ -98,7 +129,7 @@ func (r *Repository) GetTaskByID(id uuid.UUID) (*models.Task, error) {
 	if !exists {
 		return nil, ErrTaskNotFound
 	}
-	return task, nil
+	return cloneTask(task), nil
 }
 
 func (r *Repository) UpdateTask(task *models.Task) error {
@@ -113,107 +144,344 @@ func (r *Repository) UpdateTask(task *models.Task) error {
 	return nil
 }
 
-func (r *Repository) GetNextPendingTask() (*models.Task, error) {
+// GetNextPendingTasks leases up to max tasks from the head of the ready
+// queue to the given agent. Each lease lasts for the task's operation time
+// plus the given slack; copies of the leased tasks are returned so callers
+// can read them without the lock.
+func (r *Repository) GetNextPendingTasks(max int, agentID uuid.UUID, leaseSlack time.Duration) ([]*models.Task, error) {
+	r.taskMutex.Lock()
+	defer r.taskMutex.Unlock()
+
+	var tasks []*models.Task
+	for len(r.ready) > 0 && len(tasks) < max {
+		task := r.ready[0]
+		r.ready[0] = nil
+		r.ready = r.ready[1:]
+
+		// Tasks cancelled while queued are dropped lazily.
+		if task.Status != models.TaskStatusPending {
+			continue
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(time.Duration(task.OperationTime)*time.Millisecond + leaseSlack)
+		task.Status = models.TaskStatusProcessing
+		task.StartedAt = &now
+		task.LeaseToken = uuid.New()
+		task.LeaseExpiresAt = &expiresAt
+		task.AgentID = agentID
+		r.leased[task.ID] = task
+
+		leased := *task
+		tasks = append(tasks, &leased)
+	}
+
+	if len(tasks) == 0 {
+		return nil, ErrNoTasksAvailable
+	}
+	return tasks, nil
+}
+
+// CompleteTask applies a result to a task, provided the caller still holds
+// its lease. The apply function runs under the task lock.
+func (r *Repository) CompleteTask(id, leaseToken uuid.UUID, apply func(task *models.Task)) (*models.Task, error) {
 	r.taskMutex.Lock()
 	defer r.taskMutex.Unlock()
 
-	for _, task := range r.tasks {
-		if task.Status == models.TaskStatusPending {
-			allDependenciesResolved := true
-			if task.Dependencies != nil {
-				for _, depID := range task.Dependencies {
-					if depTask, exists := r.tasks[*depID]; exists {
-						if depTask.Status != models.TaskStatusCompleted {
-							allDependenciesResolved = false
-							break
-						}
-						
-						if depTask.Result != nil {
-							if task.Arg1 != nil && task.Arg1.ID == *depID {
-								task.Arg1Value = *depTask.Result
-							}
-							if task.Arg2 != nil && task.Arg2.ID == *depID {
-								task.Arg2Value = *depTask.Result
-							}
-						}
-					} else {
-						allDependenciesResolved = false
-						break
-					}
+	task, exists := r.tasks[id]
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+
+	if task.Status == models.TaskStatusCancelled {
+		return nil, ErrTaskCancelled
+	}
+	if task.Status != models.TaskStatusProcessing || task.LeaseToken != leaseToken {
+		return nil, ErrLeaseMismatch
+	}
+
+	apply(task)
+	task.LeaseToken = uuid.Nil
+	task.LeaseExpiresAt = nil
+	delete(r.leased, task.ID)
+
+	if task.Status == models.TaskStatusCompleted {
+		r.resolveDependents(task)
+	}
+	return task, nil
+}
+
+// resolveDependents passes a completed task's result to the tasks consuming
+// it and queues those that have no unresolved dependencies left.
+func (r *Repository) resolveDependents(task *models.Task) {
+	for _, dependentID := range task.Dependents {
+		dependent, exists := r.tasks[dependentID]
+		if !exists {
+			continue
+		}
+
+		for i, depID := range dependent.Dependencies {
+			if *depID == task.ID {
+				dependent.Args[i] = *task.Result
+				if task.ExactResult != nil {
+					dependent.ExactArgs[i] = *task.ExactResult
 				}
 			}
+		}
 
-			if allDependenciesResolved {
-				now := time.Now()
-				task.Status = models.TaskStatusProcessing
-				task.StartedAt = &now
-				return task, nil
-			}
+		dependent.PendingDependencies--
+		if dependent.PendingDependencies == 0 && dependent.Status == models.TaskStatusPending {
+			r.ready = append(r.ready, dependent)
 		}
 	}
+}
+
+// RequeueExpiredTasks returns every PROCESSING task whose lease ended before
+// now to PENDING, so that another agent can pick it up.
+func (r *Repository) RequeueExpiredTasks(now time.Time) ([]uuid.UUID, error) {
+	r.taskMutex.Lock()
+	defer r.taskMutex.Unlock()
 
-	return nil, ErrNoTasksAvailable
+	var requeued []uuid.UUID
+	for id, task := range r.leased {
+		if task.LeaseExpiresAt.Before(now) {
+			r.requeue(task)
+			requeued = append(requeued, id)
+		}
+	}
+	return requeued, nil
 }
 
-func (r *Repository) GetTasksByExpressionID(expressionID uuid.UUID) []*models.Task {
-	r.taskMutex.RLock()
-	defer r.taskMutex.RUnlock()
+// RequeueAgentTasks returns every task leased to the given agent to the
+// ready queue, revoking the leases.
+func (r *Repository) RequeueAgentTasks(agentID uuid.UUID) ([]uuid.UUID, error) {
+	r.taskMutex.Lock()
+	defer r.taskMutex.Unlock()
 
-	var result []*models.Task
-	for _, task := range r.tasks {
-		if task.ExpressionID == expressionID {
-			result = append(result, task)
+	var requeued []uuid.UUID
+	for id, task := range r.leased {
+		if task.AgentID == agentID {
+			r.requeue(task)
+			requeued = append(requeued, id)
 		}
 	}
-	return result
+	return requeued, nil
 }
 
-func (r *Repository) CheckExpressionCompletion(expressionID uuid.UUID) {
+// ReleaseTask returns a task to the ready queue on behalf of the agent
+// holding its lease, which gives it up without a result.
+func (r *Repository) ReleaseTask(id, leaseToken uuid.UUID) error {
+	r.taskMutex.Lock()
+	defer r.taskMutex.Unlock()
+
+	task, exists := r.tasks[id]
+	if !exists {
+		return ErrTaskNotFound
+	}
+
+	if task.Status == models.TaskStatusCancelled {
+		return ErrTaskCancelled
+	}
+	if task.Status != models.TaskStatusProcessing || task.LeaseToken != leaseToken {
+		return ErrLeaseMismatch
+	}
+
+	r.requeue(task)
+	return nil
+}
+
+// requeue revokes the lease of a PROCESSING task and puts it back on the
+// ready queue. The caller must hold taskMutex.
+func (r *Repository) requeue(task *models.Task) {
+	task.Status = models.TaskStatusPending
+	task.StartedAt = nil
+	task.LeaseToken = uuid.Nil
+	task.LeaseExpiresAt = nil
+	task.AgentID = uuid.Nil
+	delete(r.leased, task.ID)
+	r.ready = append(r.ready, task)
+}
+
+// FailExpression marks an expression as failed because one of its tasks
+// failed, and cancels every sibling task that has not finished yet.
+// Expressions that have already finished are left as they are.
+func (r *Repository) FailExpression(expressionID uuid.UUID, taskErr *models.TaskError) error {
 	r.expressionMutex.Lock()
 	defer r.expressionMutex.Unlock()
-	
+
 	expr, exists := r.expressions[expressionID]
 	if !exists {
-		return
+		return ErrExpressionNotFound
+	}
+	if expr.Status.IsFinal() {
+		return nil
 	}
 
-	tasks := r.GetTasksByExpressionID(expressionID)
-	
-	allCompleted := true
-	var finalTask *models.Task
-	
-	for _, task := range tasks {
-		if task.Status != models.TaskStatusCompleted {
-			allCompleted = false
-			break
+	expr.Status = models.StatusError
+	expr.Error = taskErr.Message
+	expr.ErrorCode = taskErr.Code
+	r.touch(expr)
+
+	r.cancelTasks(expressionID)
+	return nil
+}
+
+// CancelExpression stops an unfinished expression: it is marked CANCELLED
+// and its remaining tasks are withdrawn from dispatch.
+func (r *Repository) CancelExpression(expressionID uuid.UUID) (*models.Expression, error) {
+	r.expressionMutex.Lock()
+	defer r.expressionMutex.Unlock()
+
+	expr, exists := r.expressions[expressionID]
+	if !exists {
+		return nil, ErrExpressionNotFound
+	}
+	if expr.Status == models.StatusCancelled {
+		return cloneExpression(expr), nil
+	}
+	if expr.Status.IsFinal() {
+		return nil, ErrExpressionFinished
+	}
+
+	expr.Status = models.StatusCancelled
+	r.touch(expr)
+
+	r.cancelTasks(expressionID)
+	return cloneExpression(expr), nil
+}
+
+// cancelTasks cancels every unfinished task of an expression. Cancelled
+// tasks still in the ready queue are skipped when dequeued.
+func (r *Repository) cancelTasks(expressionID uuid.UUID) {
+	r.taskMutex.Lock()
+	defer r.taskMutex.Unlock()
+
+	for _, task := range r.tasksByExpression[expressionID] {
+		if task.Status == models.TaskStatusPending || task.Status == models.TaskStatusProcessing {
+			task.Status = models.TaskStatusCancelled
+			task.LeaseToken = uuid.Nil
+			task.LeaseExpiresAt = nil
+			delete(r.leased, task.ID)
 		}
-		
-		isDependency := false
-		for _, t := range tasks {
-			if t.Dependencies != nil {
-				for _, depID := range t.Dependencies {
-					if *depID == task.ID {
-						isDependency = true
-						break
-					}
-				}
-			}
-			if isDependency {
-				break
-			}
+	}
+}
+
+func (r *Repository) GetTasksByExpressionID(expressionID uuid.UUID) ([]*models.Task, error) {
+	r.taskMutex.RLock()
+	defer r.taskMutex.RUnlock()
+
+	tasks := r.tasksByExpression[expressionID]
+	result := make([]*models.Task, len(tasks))
+	for i, task := range tasks {
+		result[i] = cloneTask(task)
+	}
+	return result, nil
+}
+
+// cloneTask copies a task so that callers can read it while the expression
+// is being computed.
+func cloneTask(task *models.Task) *models.Task {
+	clone := *task
+	clone.Args = append([]models.Float(nil), task.Args...)
+	clone.ExactArgs = append([]string(nil), task.ExactArgs...)
+	return &clone
+}
+
+// GetExpressionProgress counts the operations of an expression and those
+// completed, leaving out its values.
+func (r *Repository) GetExpressionProgress(expressionID uuid.UUID) (models.Progress, error) {
+	r.taskMutex.RLock()
+	defer r.taskMutex.RUnlock()
+
+	var progress models.Progress
+	for _, task := range r.tasksByExpression[expressionID] {
+		if task.Operation == models.OperationValue {
+			continue
 		}
-		
-		if !isDependency {
-			finalTask = task
+		progress.Total++
+		if task.Status == models.TaskStatusCompleted {
+			progress.Completed++
 		}
 	}
-	
-	if allCompleted && finalTask != nil && finalTask.Result != nil {
+	return progress, nil
+}
+
+func (r *Repository) CheckExpressionCompletion(expressionID uuid.UUID) error {
+	r.expressionMutex.Lock()
+	defer r.expressionMutex.Unlock()
+
+	expr, exists := r.expressions[expressionID]
+	if !exists {
+		return ErrExpressionNotFound
+	}
+	// A failed or cancelled expression stays so even if its root task
+	// completed meanwhile.
+	if expr.Status.IsFinal() {
+		return nil
+	}
+
+	r.taskMutex.RLock()
+	finalTask := r.rootTasks[expressionID]
+	var result *models.Float
+	var exactResult *string
+	if finalTask != nil && finalTask.Status == models.TaskStatusCompleted {
+		result = finalTask.Result
+		exactResult = finalTask.ExactResult
+	}
+	r.taskMutex.RUnlock()
+
+	if result != nil {
 		expr.Status = models.StatusCompleted
-		expr.Result = finalTask.Result
-		expr.UpdatedAt = time.Now()
+		expr.Result = result
+		expr.ExactResult = exactResult
+		r.touch(expr)
 	} else if expr.Status == models.StatusPending {
 		expr.Status = models.StatusComputing
-		expr.UpdatedAt = time.Now()
+		r.touch(expr)
 	}
+	return nil
+}
+
+// RecordWebhookAttempt appends a delivery attempt to the webhook of an
+// expression and sets its status. The webhook is replaced rather than
+// modified, as readers hold on to it without the lock.
+func (r *Repository) RecordWebhookAttempt(expressionID uuid.UUID, attempt models.WebhookAttempt, status models.WebhookStatus) error {
+	r.expressionMutex.Lock()
+	defer r.expressionMutex.Unlock()
+
+	expr, exists := r.expressions[expressionID]
+	if !exists {
+		return ErrExpressionNotFound
+	}
+	if expr.Webhook == nil {
+		return ErrNoWebhook
+	}
+
+	webhook := &models.Webhook{
+		URL:      expr.Webhook.URL,
+		Status:   status,
+		Attempts: append(slices.Clip(expr.Webhook.Attempts), attempt),
+	}
+	expr.Webhook = webhook
+	return nil
+}
+
+// GetPendingWebhooks returns the finished expressions whose webhooks are
+// still to be delivered.
+func (r *Repository) GetPendingWebhooks() ([]*models.Expression, error) {
+	r.expressionMutex.RLock()
+	defer r.expressionMutex.RUnlock()
+
+	var result []*models.Expression
+	for _, expr := range r.expressions {
+		if expr.Webhook != nil && expr.Webhook.Status == models.WebhookPending && expr.Status.IsFinal() {
+			result = append(result, cloneExpression(expr))
+		}
+	}
+	return result, nil
+}
+
+// Close is a no-op; the in-memory repository holds no resources.
+func (r *Repository) Close() error {
+	return nil
 }
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// historySizes are the numbers of finished tasks the scheduler benchmarks
+// run against. Fetch and completion latency should not grow with them.
+var historySizes = []int{0, 10_000, 100_000, 1_000_000}
+
+// seedHistory stores n completed tasks, ten per expression, as left behind
+// by finished expressions.
+func seedHistory(b *testing.B, r *Repository, n int) {
+	b.Helper()
+	result := models.Float(1)
+	for saved := 0; saved < n; {
+		expressionID := uuid.New()
+		tasks := make([]*models.Task, 0, 10)
+		for i := 0; i < 10 && saved < n; i++ {
+			tasks = append(tasks, &models.Task{
+				ID:           uuid.New(),
+				ExpressionID: expressionID,
+				Args:         []models.Float{1, 1},
+				Operation:    models.OperationAddition,
+				Status:       models.TaskStatusCompleted,
+				Result:       &result,
+				Dependents:   []uuid.UUID{uuid.New()},
+			})
+			saved++
+		}
+		if err := r.SaveTasks(tasks); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// readyPairs stores n expressions of two tasks each, a ready leaf and the
+// root negating its result.
+func readyPairs(b *testing.B, r *Repository, n int) {
+	b.Helper()
+	for i := 0; i < n; i++ {
+		expressionID := uuid.New()
+		leaf := &models.Task{
+			ID:           uuid.New(),
+			ExpressionID: expressionID,
+			Args:         []models.Float{1, 1},
+			Operation:    models.OperationAddition,
+			Status:       models.TaskStatusPending,
+		}
+		root := &models.Task{
+			ID:                  uuid.New(),
+			ExpressionID:        expressionID,
+			Args:                []models.Float{0},
+			Operation:           models.OperationNegation,
+			Status:              models.TaskStatusPending,
+			Dependencies:        []*uuid.UUID{&leaf.ID},
+			PendingDependencies: 1,
+		}
+		leaf.Dependents = []uuid.UUID{root.ID}
+		if err := r.SaveTasks([]*models.Task{leaf, root}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetNextPendingTasks(b *testing.B) {
+	for _, history := range historySizes {
+		b.Run(fmt.Sprintf("history=%d", history), func(b *testing.B) {
+			r := NewRepository()
+			seedHistory(b, r, history)
+			readyPairs(b, r, b.N)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := r.GetNextPendingTasks(1, uuid.Nil, time.Second); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCompleteTask measures saving a task's result, which resolves its
+// dependent and queues it.
+func BenchmarkCompleteTask(b *testing.B) {
+	result := models.Float(2)
+	apply := func(task *models.Task) {
+		task.Status = models.TaskStatusCompleted
+		task.Result = &result
+	}
+
+	for _, history := range historySizes {
+		b.Run(fmt.Sprintf("history=%d", history), func(b *testing.B) {
+			r := NewRepository()
+			seedHistory(b, r, history)
+			readyPairs(b, r, b.N)
+			leased, err := r.GetNextPendingTasks(b.N, uuid.Nil, time.Hour)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+
+			for _, task := range leased {
+				if _, err := r.CompleteTask(task.ID, task.LeaseToken, apply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// testBackend opens an empty store of one backend, and backdates the
+// creation of its expressions, which the Store interface leaves to the
+// clock.
+type testBackend struct {
+	name       string
+	open       func(t *testing.T) Store
+	setCreated func(t *testing.T, store Store, id uuid.UUID, at time.Time)
+}
+
+var testBackends = []testBackend{
+	{
+		name: BackendMemory,
+		open: func(t *testing.T) Store { return NewRepository() },
+		setCreated: func(t *testing.T, store Store, id uuid.UUID, at time.Time) {
+			r := store.(*Repository)
+			r.expressions[id].CreatedAt = at
+			r.byCreated = nil
+			for _, expr := range r.expressions {
+				r.byCreated.insert(indexEntry{key: expr.CreatedAt.UnixNano(), id: expr.ID})
+			}
+		},
+	},
+	{
+		name: BackendSQLite,
+		open: func(t *testing.T) Store {
+			store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "calc.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		setCreated: func(t *testing.T, store Store, id uuid.UUID, at time.Time) {
+			_, err := store.(*SQLiteStore).db.Exec(`UPDATE expressions SET created_at = ? WHERE id = ?`, at.UnixNano(), id.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	},
+}
+
+// TestListExpressionsCursor walks every ordering page by page, passing the
+// cursor through its string form, and checks that the pages add up to the
+// whole list: no expression is skipped or repeated, including among those
+// with equal timestamps.
+func TestListExpressionsCursor(t *testing.T) {
+	const count, pageSize = 11, 3
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			for i := 0; i < count; i++ {
+				expr, err := store.CreateExpression(uuid.New(), "1+1", nil, models.Precision{Mode: models.PrecisionFloat64}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i%2 == 0 {
+					// Updating twice leaves stale entries in the memory index.
+					expr.Status = models.StatusComputing
+					store.UpdateExpression(expr)
+					expr.Status = models.StatusCompleted
+					store.UpdateExpression(expr)
+				}
+				// Expressions are created in threes at the same instant.
+				backend.setCreated(t, store, expr.ID, base.Add(time.Duration(i/pageSize)*time.Second))
+			}
+
+			all, err := store.ListExpressions(models.ExpressionQuery{Limit: count})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all.Expressions) != count {
+				t.Fatalf("listed %d expressions, want %d", len(all.Expressions), count)
+			}
+
+			for _, sort := range []models.ExpressionSort{models.SortCreatedAt, models.SortUpdatedAt} {
+				for _, descending := range []bool{false, true} {
+					for _, statuses := range [][]models.ExpressionStatus{nil, {models.StatusCompleted}} {
+						query := models.ExpressionQuery{Statuses: statuses, Sort: sort, Descending: descending, Limit: pageSize}
+						name := fmt.Sprintf("sort=%s descending=%t statuses=%v", sort, descending, statuses)
+						want := expectedOrder(all.Expressions, query)
+						got := walkPages(t, store, query, len(want))
+						if !slices.Equal(got, want) {
+							t.Errorf("%s: pages list\n%v\nwant\n%v", name, got, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// expectedOrder filters and sorts the expressions as the query should.
+func expectedOrder(exprs []*models.Expression, query models.ExpressionQuery) []uuid.UUID {
+	var cursors []models.ExpressionCursor
+	for _, expr := range exprs {
+		if query.Matches(expr) {
+			cursors = append(cursors, models.CursorFor(expr, query.Sort))
+		}
+	}
+	slices.SortFunc(cursors, func(a, b models.ExpressionCursor) int {
+		c := compareEntries(indexEntry{key: a.Key, id: a.ID}, indexEntry{key: b.Key, id: b.ID})
+		if query.Descending {
+			return -c
+		}
+		return c
+	})
+
+	ids := make([]uuid.UUID, len(cursors))
+	for i, cursor := range cursors {
+		ids[i] = cursor.ID
+	}
+	return ids
+}
+
+// walkPages lists the query page by page and returns the IDs in the order
+// listed.
+func walkPages(t *testing.T, store Store, query models.ExpressionQuery, total int) []uuid.UUID {
+	t.Helper()
+	var ids []uuid.UUID
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatalf("still listing after %d pages", pages)
+		}
+		page, err := store.ListExpressions(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != total {
+			t.Fatalf("total %d, want %d", page.Total, total)
+		}
+		for _, expr := range page.Expressions {
+			ids = append(ids, expr.ID)
+		}
+		if page.Next == nil {
+			return ids
+		}
+
+		token := page.Next.String()
+		cursor, err := models.ParseExpressionCursor(token)
+		if err != nil {
+			t.Fatalf("ParseExpressionCursor(%q): %v", token, err)
+		}
+		if cursor != *page.Next {
+			t.Fatalf("cursor %+v came back from %q as %+v", *page.Next, token, cursor)
+		}
+		query.After = &cursor
+	}
+}
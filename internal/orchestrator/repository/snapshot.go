@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// snapshot is the on-disk form of a Repository.
+type snapshot struct {
+	Expressions []*models.Expression `json:"expressions"`
+	// Tasks are grouped by expression, in the order they were saved.
+	Tasks []*snapshotTask `json:"tasks"`
+	// Ready lists the queued tasks in dispatch order.
+	Ready []uuid.UUID `json:"ready"`
+}
+
+// snapshotTask holds every field of a task, including the DAG links that
+// the API representation omits. Leases are not kept.
+type snapshotTask struct {
+	ID                  uuid.UUID            `json:"id"`
+	ExpressionID        uuid.UUID            `json:"expression_id"`
+	Args                []models.Float       `json:"args"`
+	Operation           models.OperationType `json:"operation"`
+	OperationTime       int                  `json:"operation_time"`
+	Status              models.TaskStatus    `json:"status"`
+	Result              *models.Float        `json:"result,omitempty"`
+	Error               string               `json:"error,omitempty"`
+	ErrorCode           models.TaskErrorCode `json:"error_code,omitempty"`
+	Dependencies        []*uuid.UUID         `json:"dependencies"`
+	Dependents          []uuid.UUID          `json:"dependents"`
+	PendingDependencies int                  `json:"pending_dependencies"`
+	CreatedAt           time.Time            `json:"created_at"`
+	StartedAt           *time.Time           `json:"started_at,omitempty"`
+	CompletedAt         *time.Time           `json:"completed_at,omitempty"`
+	Precision           models.Precision     `json:"precision"`
+	ExactArgs           []string             `json:"exact_args,omitempty"`
+	ExactResult         *string              `json:"exact_result,omitempty"`
+}
+
+// SaveSnapshot writes the expressions and tasks of the repository to path,
+// replacing the file atomically.
+func (r *Repository) SaveSnapshot(path string) error {
+	r.expressionMutex.RLock()
+	defer r.expressionMutex.RUnlock()
+	r.taskMutex.RLock()
+	defer r.taskMutex.RUnlock()
+
+	snap := snapshot{
+		Expressions: make([]*models.Expression, 0, len(r.expressions)),
+		Tasks:       make([]*snapshotTask, 0, len(r.tasks)),
+		Ready:       make([]uuid.UUID, 0, len(r.ready)),
+	}
+	for id, expr := range r.expressions {
+		snap.Expressions = append(snap.Expressions, expr)
+		for _, task := range r.tasksByExpression[id] {
+			snap.Tasks = append(snap.Tasks, &snapshotTask{
+				ID:                  task.ID,
+				ExpressionID:        task.ExpressionID,
+				Args:                task.Args,
+				Operation:           task.Operation,
+				OperationTime:       task.OperationTime,
+				Status:              task.Status,
+				Result:              task.Result,
+				Error:               task.Error,
+				ErrorCode:           task.ErrorCode,
+				Dependencies:        task.Dependencies,
+				Dependents:          task.Dependents,
+				PendingDependencies: task.PendingDependencies,
+				CreatedAt:           task.CreatedAt,
+				StartedAt:           task.StartedAt,
+				CompletedAt:         task.CompletedAt,
+				Precision:           task.Precision,
+				ExactArgs:           task.ExactArgs,
+				ExactResult:         task.ExactResult,
+			})
+		}
+	}
+	for _, task := range r.ready {
+		// Tasks cancelled while queued are dropped here as on dequeue.
+		if task.Status == models.TaskStatusPending {
+			snap.Ready = append(snap.Ready, task.ID)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadRepository returns a repository holding the snapshot at path, or an
+// empty one if there is no such file. Tasks that were PROCESSING when the
+// snapshot was taken are returned to PENDING so that computation resumes.
+func LoadRepository(path string) (*Repository, error) {
+	r := NewRepository()
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var snap snapshot
+	if err := json.NewDecoder(file).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+
+	for _, expr := range snap.Expressions {
+		r.expressions[expr.ID] = expr
+		r.indexExpression(expr)
+	}
+
+	var processing []*models.Task
+	for _, saved := range snap.Tasks {
+		task := &models.Task{
+			ID:                  saved.ID,
+			ExpressionID:        saved.ExpressionID,
+			Args:                saved.Args,
+			Operation:           saved.Operation,
+			OperationTime:       saved.OperationTime,
+			Status:              saved.Status,
+			Result:              saved.Result,
+			Error:               saved.Error,
+			ErrorCode:           saved.ErrorCode,
+			Dependencies:        saved.Dependencies,
+			Dependents:          saved.Dependents,
+			PendingDependencies: saved.PendingDependencies,
+			CreatedAt:           saved.CreatedAt,
+			StartedAt:           saved.StartedAt,
+			CompletedAt:         saved.CompletedAt,
+			Precision:           saved.Precision,
+			ExactArgs:           saved.ExactArgs,
+			ExactResult:         saved.ExactResult,
+		}
+		r.tasks[task.ID] = task
+		r.tasksByExpression[task.ExpressionID] = append(r.tasksByExpression[task.ExpressionID], task)
+		if len(task.Dependents) == 0 {
+			r.rootTasks[task.ExpressionID] = task
+		}
+		if task.Status == models.TaskStatusProcessing {
+			task.Status = models.TaskStatusPending
+			task.StartedAt = nil
+			processing = append(processing, task)
+		}
+	}
+
+	for _, id := range snap.Ready {
+		if task, exists := r.tasks[id]; exists {
+			r.ready = append(r.ready, task)
+		}
+	}
+	r.ready = append(r.ready, processing...)
+	return r, nil
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// saveNegatedSum stores an expression of two tasks: a ready leaf adding 1
+// and 2, and the root negating its result.
+func saveNegatedSum(t *testing.T, store Store) (leaf, root *models.Task) {
+	t.Helper()
+	precision := models.Precision{Mode: models.PrecisionFloat64}
+	expr, err := store.CreateExpression(uuid.New(), "-(1+2)", nil, precision, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf = &models.Task{
+		ID:           uuid.New(),
+		ExpressionID: expr.ID,
+		Args:         []models.Float{1, 2},
+		Operation:    models.OperationAddition,
+		Precision:    precision,
+		Status:       models.TaskStatusPending,
+	}
+	root = &models.Task{
+		ID:                  uuid.New(),
+		ExpressionID:        expr.ID,
+		Args:                []models.Float{0},
+		Operation:           models.OperationNegation,
+		Precision:           precision,
+		Status:              models.TaskStatusPending,
+		Dependencies:        []*uuid.UUID{&leaf.ID},
+		PendingDependencies: 1,
+	}
+	leaf.Dependents = []uuid.UUID{root.ID}
+	if err := store.SaveTasks([]*models.Task{leaf, root}); err != nil {
+		t.Fatal(err)
+	}
+	return leaf, root
+}
+
+// TestSnapshotRoundTrip saves a repository mid-computation and loads it
+// back: results and the DAG survive, the ready queue keeps its order and
+// the task that was leased is queued again.
+func TestSnapshotRoundTrip(t *testing.T) {
+	r := NewRepository()
+	queued := saveReadyTask(t, r)
+	leaf, root := saveNegatedSum(t, r)
+
+	leased, err := r.GetNextPendingTasks(2, uuid.New(), time.Hour)
+	if err != nil || len(leased) != 2 || leased[1].ID != leaf.ID {
+		t.Fatalf("leased %v, %v, want the single task and the leaf", leased, err)
+	}
+	if _, err := r.CompleteTask(leaf.ID, leased[1].LeaseToken, completeWith(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckExpressionCompletion(leaf.ExpressionID); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := r.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []uuid.UUID{queued.ExpressionID, leaf.ExpressionID} {
+		want, _ := r.GetExpressionByID(id)
+		got, err := loaded.GetExpressionByID(id)
+		if err != nil {
+			t.Fatalf("expression %s lost: %v", id, err)
+		}
+		if got.Expression != want.Expression || got.Status != want.Status || !got.CreatedAt.Equal(want.CreatedAt) {
+			t.Errorf("expression %s loaded as %q %s created %s, want %q %s created %s",
+				id, got.Expression, got.Status, got.CreatedAt, want.Expression, want.Status, want.CreatedAt)
+		}
+	}
+	page, err := loaded.ListExpressions(models.ExpressionQuery{Limit: 10})
+	if err != nil || len(page.Expressions) != 2 {
+		t.Errorf("listed %v, %v after loading, want both expressions", page, err)
+	}
+
+	saved, err := loaded.GetTaskByID(leaf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != models.TaskStatusCompleted || saved.Result == nil || *saved.Result != 3 {
+		t.Errorf("leaf loaded as %s with result %v, want COMPLETED 3", saved.Status, saved.Result)
+	}
+
+	// The root was queued by the leaf's result, the single task by the load.
+	next, err := loaded.GetNextPendingTasks(3, uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next) != 2 || next[0].ID != root.ID || next[1].ID != queued.ID {
+		t.Fatalf("dispatched %v after loading, want the root then the single task", next)
+	}
+	if next[0].Args[0] != 3 || next[0].PendingDependencies != 0 {
+		t.Errorf("root loaded with args %v and %d pending dependencies, want [3] and 0", next[0].Args, next[0].PendingDependencies)
+	}
+
+	// Completing the root still finishes the expression.
+	if _, err := loaded.CompleteTask(root.ID, next[0].LeaseToken, completeWith(-3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.CheckExpressionCompletion(root.ExpressionID); err != nil {
+		t.Fatal(err)
+	}
+	expr, _ := loaded.GetExpressionByID(root.ExpressionID)
+	if expr.Status != models.StatusCompleted || expr.Result == nil || *expr.Result != -3 {
+		t.Errorf("expression is %s with result %v, want COMPLETED -3", expr.Status, expr.Result)
+	}
+}
+
+func TestLoadRepositoryWithoutSnapshot(t *testing.T) {
+	r, err := LoadRepository(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetNextPendingTasks(1, uuid.New(), time.Hour); !errors.Is(err, ErrNoTasksAvailable) {
+		t.Errorf("fresh repository: got %v, want ErrNoTasksAvailable", err)
+	}
+}
@@ -0,0 +1,1098 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS expressions (
+	id             TEXT PRIMARY KEY,
+	expression     TEXT NOT NULL,
+	variables      TEXT NOT NULL DEFAULT '',
+	status         TEXT NOT NULL,
+	result         TEXT,
+	error          TEXT NOT NULL DEFAULT '',
+	error_code     TEXT NOT NULL DEFAULT '',
+	root_task_id   TEXT,
+	created_at     INTEGER NOT NULL,
+	updated_at     INTEGER NOT NULL,
+	precision      TEXT NOT NULL DEFAULT '',
+	exact_result   TEXT,
+	webhook        TEXT NOT NULL DEFAULT '',
+	webhook_status TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+	id                   TEXT PRIMARY KEY,
+	expression_id        TEXT NOT NULL,
+	operation            TEXT NOT NULL,
+	operation_time       INTEGER NOT NULL,
+	args                 TEXT NOT NULL,
+	dependencies         TEXT NOT NULL,
+	dependents           TEXT NOT NULL,
+	pending_dependencies INTEGER NOT NULL,
+	status               TEXT NOT NULL,
+	result               TEXT,
+	error                TEXT NOT NULL DEFAULT '',
+	error_code           TEXT NOT NULL DEFAULT '',
+	ready_seq            INTEGER,
+	lease_token          TEXT,
+	lease_expires_at     INTEGER,
+	created_at           INTEGER NOT NULL,
+	started_at           INTEGER,
+	completed_at         INTEGER,
+	agent_id             TEXT,
+	precision            TEXT NOT NULL DEFAULT '',
+	exact_args           TEXT NOT NULL DEFAULT '',
+	exact_result         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS expressions_by_created ON expressions (created_at, id);
+CREATE INDEX IF NOT EXISTS expressions_by_updated ON expressions (updated_at, id);
+CREATE INDEX IF NOT EXISTS expressions_by_status ON expressions (status, created_at);
+CREATE INDEX IF NOT EXISTS tasks_by_expression ON tasks (expression_id);
+CREATE INDEX IF NOT EXISTS tasks_ready ON tasks (ready_seq) WHERE status = 'PENDING' AND pending_dependencies = 0;
+CREATE INDEX IF NOT EXISTS tasks_leased ON tasks (lease_expires_at) WHERE status = 'PROCESSING';
+`
+
+// sqliteIndexes lists the indexes on columns added by migrations, which are
+// created once the columns exist.
+const sqliteIndexes = `
+CREATE INDEX IF NOT EXISTS expressions_webhooks ON expressions (webhook_status) WHERE webhook_status = 'PENDING';
+`
+
+// sqliteColumnMigrations lists columns added after the initial schema, as
+// "table", "column definition" pairs. They are added to databases created
+// before them.
+var sqliteColumnMigrations = [][2]string{
+	{"tasks", "agent_id TEXT"},
+	{"expressions", "precision TEXT NOT NULL DEFAULT ''"},
+	{"expressions", "exact_result TEXT"},
+	{"tasks", "precision TEXT NOT NULL DEFAULT ''"},
+	{"tasks", "exact_args TEXT NOT NULL DEFAULT ''"},
+	{"tasks", "exact_result TEXT"},
+	{"expressions", "webhook TEXT NOT NULL DEFAULT ''"},
+	{"expressions", "webhook_status TEXT NOT NULL DEFAULT ''"},
+}
+
+const expressionColumns = `id, expression, variables, status, result, error, error_code, created_at, updated_at,
+	precision, exact_result, webhook`
+
+const taskColumns = `id, expression_id, operation, operation_time, args, dependencies, dependents,
+	pending_dependencies, status, result, error, error_code, lease_token, lease_expires_at,
+	created_at, started_at, completed_at, agent_id, precision, exact_args, exact_result`
+
+// SQLiteStore is a Store backed by an embedded SQLite database. Writes are
+// serialized; ready tasks are ordered by the sequence number they were
+// queued with, mirroring the FIFO of the in-memory repository.
+type SQLiteStore struct {
+	db       *sql.DB
+	mu       sync.Mutex
+	readySeq int64
+}
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path. Tasks left
+// PROCESSING by a previous run are returned to PENDING so that computation
+// resumes.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	if err := migrateColumns(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if _, err := db.Exec(sqliteIndexes); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create indexes: %w", err)
+	}
+
+	_, err = db.Exec(`UPDATE tasks SET status = ?, started_at = NULL, lease_token = NULL, lease_expires_at = NULL,
+		agent_id = NULL WHERE status = ?`, models.TaskStatusPending, models.TaskStatusProcessing)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to recover processing tasks: %w", err)
+	}
+
+	store := &SQLiteStore{db: db}
+	if err := db.QueryRow(`SELECT COALESCE(MAX(ready_seq), 0) FROM tasks`).Scan(&store.readySeq); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrateColumns adds the columns of sqliteColumnMigrations that are missing.
+func migrateColumns(db *sql.DB) error {
+	for _, migration := range sqliteColumnMigrations {
+		table, definition := migration[0], migration[1]
+		column := strings.Fields(definition)[0]
+
+		var exists int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) withTx(fn func(tx *sql.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CreateExpression(id uuid.UUID, expression string, variables map[string]float64, precision models.Precision, webhook *models.Webhook) (*models.Expression, error) {
+	expr := &models.Expression{
+		ID:         id,
+		Expression: expression,
+		Variables:  variables,
+		Precision:  precision,
+		Status:     models.StatusPending,
+		Webhook:    webhook,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	vars, err := encodeVariables(variables)
+	if err != nil {
+		return nil, err
+	}
+	prec, err := encodePrecision(precision)
+	if err != nil {
+		return nil, err
+	}
+	hook, err := encodeWebhook(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO expressions (`+expressionColumns+`, webhook_status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			expr.ID.String(), expr.Expression, vars, expr.Status, encodeResult(expr.Result),
+			expr.Error, expr.ErrorCode, expr.CreatedAt.UnixNano(), expr.UpdatedAt.UnixNano(),
+			prec, encodeExact(expr.ExactResult), hook, webhookStatus(webhook))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (s *SQLiteStore) GetExpressionByID(id uuid.UUID) (*models.Expression, error) {
+	return getExpression(s.db, id)
+}
+
+// ListExpressions returns a page of expressions from the index of the sort
+// order, fetching one row past the page to tell whether there is another.
+func (s *SQLiteStore) ListExpressions(query models.ExpressionQuery) (*models.ExpressionPage, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if len(query.Statuses) > 0 {
+		where = append(where, `status IN (?`+strings.Repeat(`, ?`, len(query.Statuses)-1)+`)`)
+		for _, status := range query.Statuses {
+			args = append(args, status)
+		}
+	}
+	if !query.CreatedFrom.IsZero() {
+		where = append(where, `created_at >= ?`)
+		args = append(args, query.CreatedFrom.UnixNano())
+	}
+	if !query.CreatedTo.IsZero() {
+		where = append(where, `created_at < ?`)
+		args = append(args, query.CreatedTo.UnixNano())
+	}
+
+	page := &models.ExpressionPage{Expressions: []*models.Expression{}}
+	filter := ``
+	if len(where) > 0 {
+		filter = ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM expressions`+filter, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	column, order, compare := `created_at`, `ASC`, `>`
+	if query.Sort == models.SortUpdatedAt {
+		column = `updated_at`
+	}
+	if query.Descending {
+		order, compare = `DESC`, `<`
+	}
+	if query.After != nil {
+		where = append(where, `(`+column+`, id) `+compare+` (?, ?)`)
+		args = append(args, query.After.Key, query.After.ID.String())
+	}
+	if len(where) > 0 {
+		filter = ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	args = append(args, query.Limit+1)
+
+	rows, err := s.db.Query(`SELECT `+expressionColumns+` FROM expressions`+filter+
+		` ORDER BY `+column+` `+order+`, id `+order+` LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Expressions) == query.Limit {
+			next := models.CursorFor(page.Expressions[len(page.Expressions)-1], query.Sort)
+			page.Next = &next
+			break
+		}
+		page.Expressions = append(page.Expressions, expr)
+	}
+	return page, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateExpression(expr *models.Expression) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		expr.UpdatedAt = time.Now()
+		return writeExpression(tx, expr)
+	})
+}
+
+func (s *SQLiteStore) SaveTasks(tasks []*models.Task) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		for _, task := range tasks {
+			var readySeq sql.NullInt64
+			if task.Status == models.TaskStatusPending && task.PendingDependencies == 0 {
+				readySeq = s.nextReadySeq()
+			}
+			prec, err := encodePrecision(task.Precision)
+			if err != nil {
+				return err
+			}
+			exactArgs, err := encodeStrings(task.ExactArgs)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT INTO tasks (`+taskColumns+`, ready_seq)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				task.ID.String(), task.ExpressionID.String(), task.Operation, task.OperationTime,
+				encodeFloats(task.Args), encodeDependencies(task.Dependencies), encodeIDs(task.Dependents),
+				task.PendingDependencies, task.Status, encodeResult(task.Result), task.Error, task.ErrorCode,
+				encodeID(task.LeaseToken), encodeTime(task.LeaseExpiresAt),
+				task.CreatedAt.UnixNano(), encodeTime(task.StartedAt), encodeTime(task.CompletedAt),
+				encodeID(task.AgentID), prec, exactArgs, encodeExact(task.ExactResult), readySeq)
+			if err != nil {
+				return err
+			}
+
+			if len(task.Dependents) == 0 {
+				_, err := tx.Exec(`UPDATE expressions SET root_task_id = ? WHERE id = ?`,
+					task.ID.String(), task.ExpressionID.String())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) GetTaskByID(id uuid.UUID) (*models.Task, error) {
+	return getTask(s.db, id)
+}
+
+func (s *SQLiteStore) UpdateTask(task *models.Task) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return writeTask(tx, task)
+	})
+}
+
+// GetNextPendingTasks leases up to max tasks from the head of the ready
+// queue to the given agent, see Repository.GetNextPendingTasks.
+func (s *SQLiteStore) GetNextPendingTasks(max int, agentID uuid.UUID, leaseSlack time.Duration) ([]*models.Task, error) {
+	var tasks []*models.Task
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+taskColumns+` FROM tasks
+			WHERE status = 'PENDING' AND pending_dependencies = 0 ORDER BY ready_seq LIMIT ?`, max)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			tasks = append(tasks, task)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return ErrNoTasksAvailable
+		}
+
+		now := time.Now()
+		for _, task := range tasks {
+			expiresAt := now.Add(time.Duration(task.OperationTime)*time.Millisecond + leaseSlack)
+			task.Status = models.TaskStatusProcessing
+			task.StartedAt = &now
+			task.LeaseToken = uuid.New()
+			task.LeaseExpiresAt = &expiresAt
+			task.AgentID = agentID
+			if err := writeTask(tx, task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// CompleteTask applies a result to a task, provided the caller still holds
+// its lease, and resolves the tasks depending on it.
+func (s *SQLiteStore) CompleteTask(id, leaseToken uuid.UUID, apply func(task *models.Task)) (*models.Task, error) {
+	var task *models.Task
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		task, err = getTask(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if task.Status == models.TaskStatusCancelled {
+			return ErrTaskCancelled
+		}
+		if task.Status != models.TaskStatusProcessing || task.LeaseToken != leaseToken {
+			return ErrLeaseMismatch
+		}
+
+		apply(task)
+		task.LeaseToken = uuid.Nil
+		task.LeaseExpiresAt = nil
+		if err := writeTask(tx, task); err != nil {
+			return err
+		}
+
+		if task.Status == models.TaskStatusCompleted {
+			return s.resolveDependents(tx, task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (s *SQLiteStore) resolveDependents(tx *sql.Tx, task *models.Task) error {
+	for _, dependentID := range task.Dependents {
+		dependent, err := getTask(tx, dependentID)
+		if errors.Is(err, ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, depID := range dependent.Dependencies {
+			if *depID == task.ID {
+				dependent.Args[i] = *task.Result
+				if task.ExactResult != nil {
+					dependent.ExactArgs[i] = *task.ExactResult
+				}
+			}
+		}
+
+		dependent.PendingDependencies--
+		if err := writeTask(tx, dependent); err != nil {
+			return err
+		}
+
+		if dependent.PendingDependencies == 0 && dependent.Status == models.TaskStatusPending {
+			if err := s.enqueue(tx, dependent.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RequeueExpiredTasks(now time.Time) ([]uuid.UUID, error) {
+	return s.requeueWhere(`lease_expires_at < ? ORDER BY lease_expires_at`, now.UnixNano())
+}
+
+// RequeueAgentTasks returns every task leased to the given agent to the
+// ready queue, revoking the leases.
+func (s *SQLiteStore) RequeueAgentTasks(agentID uuid.UUID) ([]uuid.UUID, error) {
+	return s.requeueWhere(`agent_id = ? ORDER BY lease_expires_at`, agentID.String())
+}
+
+// ReleaseTask returns a task to the ready queue on behalf of the agent
+// holding its lease, see Repository.ReleaseTask.
+func (s *SQLiteStore) ReleaseTask(id, leaseToken uuid.UUID) error {
+	task, err := s.GetTaskByID(id)
+	if err != nil {
+		return err
+	}
+	if task.Status == models.TaskStatusCancelled {
+		return ErrTaskCancelled
+	}
+	if task.Status != models.TaskStatusProcessing || task.LeaseToken != leaseToken {
+		return ErrLeaseMismatch
+	}
+
+	released, err := s.requeueWhere(`id = ? AND lease_token = ?`, id.String(), leaseToken.String())
+	if err != nil {
+		return err
+	}
+	if len(released) == 0 {
+		// The lease changed hands after the check above.
+		return ErrLeaseMismatch
+	}
+	return nil
+}
+
+// requeueWhere revokes the leases of the PROCESSING tasks matching the given
+// condition and puts them back on the ready queue.
+func (s *SQLiteStore) requeueWhere(condition string, args ...interface{}) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id FROM tasks WHERE status = 'PROCESSING' AND `+condition, args...)
+		if err != nil {
+			return err
+		}
+		ids, err = scanIDs(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			_, err := tx.Exec(`UPDATE tasks SET status = ?, started_at = NULL, lease_token = NULL, lease_expires_at = NULL,
+				agent_id = NULL WHERE id = ?`, models.TaskStatusPending, id.String())
+			if err != nil {
+				return err
+			}
+			if err := s.enqueue(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *SQLiteStore) FailExpression(expressionID uuid.UUID, taskErr *models.TaskError) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		expr, err := getExpression(tx, expressionID)
+		if err != nil {
+			return err
+		}
+		if expr.Status.IsFinal() {
+			return nil
+		}
+
+		expr.Status = models.StatusError
+		expr.Error = taskErr.Message
+		expr.ErrorCode = taskErr.Code
+		expr.UpdatedAt = time.Now()
+		if err := writeExpression(tx, expr); err != nil {
+			return err
+		}
+
+		return cancelTasks(tx, expressionID)
+	})
+}
+
+// CancelExpression stops an unfinished expression, see
+// Repository.CancelExpression.
+func (s *SQLiteStore) CancelExpression(expressionID uuid.UUID) (*models.Expression, error) {
+	var expr *models.Expression
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		expr, err = getExpression(tx, expressionID)
+		if err != nil {
+			return err
+		}
+		if expr.Status == models.StatusCancelled {
+			return nil
+		}
+		if expr.Status.IsFinal() {
+			return ErrExpressionFinished
+		}
+
+		expr.Status = models.StatusCancelled
+		expr.UpdatedAt = time.Now()
+		if err := writeExpression(tx, expr); err != nil {
+			return err
+		}
+
+		return cancelTasks(tx, expressionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func cancelTasks(tx *sql.Tx, expressionID uuid.UUID) error {
+	_, err := tx.Exec(`UPDATE tasks SET status = ?, lease_token = NULL, lease_expires_at = NULL
+		WHERE expression_id = ? AND status IN (?, ?)`,
+		models.TaskStatusCancelled, expressionID.String(), models.TaskStatusPending, models.TaskStatusProcessing)
+	return err
+}
+
+func (s *SQLiteStore) GetTasksByExpressionID(expressionID uuid.UUID) ([]*models.Task, error) {
+	rows, err := s.db.Query(`SELECT `+taskColumns+` FROM tasks WHERE expression_id = ? ORDER BY rowid`,
+		expressionID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*models.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, task)
+	}
+	return result, rows.Err()
+}
+
+// GetExpressionProgress counts the operations of an expression, see
+// Repository.GetExpressionProgress.
+func (s *SQLiteStore) GetExpressionProgress(expressionID uuid.UUID) (models.Progress, error) {
+	var progress models.Progress
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(status = ?), 0) FROM tasks
+		WHERE expression_id = ? AND operation != ?`,
+		models.TaskStatusCompleted, expressionID.String(), models.OperationValue).Scan(&progress.Total, &progress.Completed)
+	return progress, err
+}
+
+func (s *SQLiteStore) CheckExpressionCompletion(expressionID uuid.UUID) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		expr, err := getExpression(tx, expressionID)
+		if err != nil {
+			return err
+		}
+		if expr.Status.IsFinal() {
+			return nil
+		}
+
+		var status models.TaskStatus
+		var result, exactResult sql.NullString
+		err = tx.QueryRow(`SELECT t.status, t.result, t.exact_result FROM expressions e
+			JOIN tasks t ON t.id = e.root_task_id WHERE e.id = ?`, expressionID.String()).Scan(&status, &result, &exactResult)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if status == models.TaskStatusCompleted && result.Valid {
+			value, err := decodeResult(result)
+			if err != nil {
+				return err
+			}
+			expr.Status = models.StatusCompleted
+			expr.Result = value
+			expr.ExactResult = decodeExact(exactResult)
+		} else if expr.Status == models.StatusPending {
+			expr.Status = models.StatusComputing
+		} else {
+			return nil
+		}
+
+		expr.UpdatedAt = time.Now()
+		return writeExpression(tx, expr)
+	})
+}
+
+// RecordWebhookAttempt appends a delivery attempt to the webhook of an
+// expression and sets its status.
+func (s *SQLiteStore) RecordWebhookAttempt(expressionID uuid.UUID, attempt models.WebhookAttempt, status models.WebhookStatus) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		expr, err := getExpression(tx, expressionID)
+		if err != nil {
+			return err
+		}
+		if expr.Webhook == nil {
+			return ErrNoWebhook
+		}
+
+		expr.Webhook.Attempts = append(expr.Webhook.Attempts, attempt)
+		expr.Webhook.Status = status
+		hook, err := encodeWebhook(expr.Webhook)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE expressions SET webhook = ?, webhook_status = ? WHERE id = ?`,
+			hook, status, expressionID.String())
+		return err
+	})
+}
+
+// GetPendingWebhooks returns the finished expressions whose webhooks are
+// still to be delivered.
+func (s *SQLiteStore) GetPendingWebhooks() ([]*models.Expression, error) {
+	rows, err := s.db.Query(`SELECT `+expressionColumns+` FROM expressions
+		WHERE webhook_status = ? AND status IN (?, ?, ?)`,
+		models.WebhookPending, models.StatusCompleted, models.StatusError, models.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*models.Expression
+	for rows.Next() {
+		expr, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expr)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// nextReadySeq must be called with s.mu held.
+func (s *SQLiteStore) nextReadySeq() sql.NullInt64 {
+	s.readySeq++
+	return sql.NullInt64{Int64: s.readySeq, Valid: true}
+}
+
+func (s *SQLiteStore) enqueue(tx *sql.Tx, id uuid.UUID) error {
+	_, err := tx.Exec(`UPDATE tasks SET ready_seq = ? WHERE id = ?`, s.nextReadySeq(), id.String())
+	return err
+}
+
+func getExpression(q querier, id uuid.UUID) (*models.Expression, error) {
+	row := q.QueryRow(`SELECT `+expressionColumns+` FROM expressions WHERE id = ?`, id.String())
+	expr, err := scanExpression(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExpressionNotFound
+	}
+	return expr, err
+}
+
+func writeExpression(tx *sql.Tx, expr *models.Expression) error {
+	vars, err := encodeVariables(expr.Variables)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`UPDATE expressions SET expression = ?, variables = ?, status = ?, result = ?,
+		error = ?, error_code = ?, updated_at = ?, exact_result = ? WHERE id = ?`,
+		expr.Expression, vars, expr.Status, encodeResult(expr.Result), expr.Error, expr.ErrorCode,
+		expr.UpdatedAt.UnixNano(), encodeExact(expr.ExactResult), expr.ID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrExpressionNotFound
+	}
+	return nil
+}
+
+func scanExpression(row rowScanner) (*models.Expression, error) {
+	var (
+		expr                 models.Expression
+		id, vars, prec, hook string
+		result, exactResult  sql.NullString
+		createdAt, updatedAt int64
+	)
+	err := row.Scan(&id, &expr.Expression, &vars, &expr.Status, &result, &expr.Error, &expr.ErrorCode,
+		&createdAt, &updatedAt, &prec, &exactResult, &hook)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.ID, err = uuid.Parse(id); err != nil {
+		return nil, err
+	}
+	if expr.Variables, err = decodeVariables(vars); err != nil {
+		return nil, err
+	}
+	if expr.Result, err = decodeResult(result); err != nil {
+		return nil, err
+	}
+	if expr.Precision, err = decodePrecision(prec); err != nil {
+		return nil, err
+	}
+	if expr.Webhook, err = decodeWebhook(hook); err != nil {
+		return nil, err
+	}
+	expr.ExactResult = decodeExact(exactResult)
+	expr.CreatedAt = time.Unix(0, createdAt)
+	expr.UpdatedAt = time.Unix(0, updatedAt)
+	return &expr, nil
+}
+
+func getTask(q querier, id uuid.UUID) (*models.Task, error) {
+	row := q.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id.String())
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+func writeTask(tx *sql.Tx, task *models.Task) error {
+	exactArgs, err := encodeStrings(task.ExactArgs)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`UPDATE tasks SET args = ?, pending_dependencies = ?, status = ?, result = ?,
+		error = ?, error_code = ?, lease_token = ?, lease_expires_at = ?, started_at = ?, completed_at = ?,
+		agent_id = ?, exact_args = ?, exact_result = ? WHERE id = ?`,
+		encodeFloats(task.Args), task.PendingDependencies, task.Status, encodeResult(task.Result),
+		task.Error, task.ErrorCode, encodeID(task.LeaseToken), encodeTime(task.LeaseExpiresAt),
+		encodeTime(task.StartedAt), encodeTime(task.CompletedAt), encodeID(task.AgentID),
+		exactArgs, encodeExact(task.ExactResult), task.ID.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+func scanTask(row rowScanner) (*models.Task, error) {
+	var (
+		task                                     models.Task
+		id, expressionID, args, deps, dependents string
+		prec, exactArgs                          string
+		result, leaseToken, agentID, exactResult sql.NullString
+		leaseExpiresAt, startedAt, completedAt   sql.NullInt64
+		createdAt                                int64
+	)
+	err := row.Scan(&id, &expressionID, &task.Operation, &task.OperationTime, &args, &deps, &dependents,
+		&task.PendingDependencies, &task.Status, &result, &task.Error, &task.ErrorCode, &leaseToken,
+		&leaseExpiresAt, &createdAt, &startedAt, &completedAt, &agentID, &prec, &exactArgs, &exactResult)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.ID, err = uuid.Parse(id); err != nil {
+		return nil, err
+	}
+	if task.ExpressionID, err = uuid.Parse(expressionID); err != nil {
+		return nil, err
+	}
+	if task.Args, err = decodeFloats(args); err != nil {
+		return nil, err
+	}
+	dependencies, err := decodeIDs(deps)
+	if err != nil {
+		return nil, err
+	}
+	task.Dependencies = make([]*uuid.UUID, len(dependencies))
+	for i := range dependencies {
+		task.Dependencies[i] = &dependencies[i]
+	}
+	if task.Dependents, err = decodeIDs(dependents); err != nil {
+		return nil, err
+	}
+	if task.Result, err = decodeResult(result); err != nil {
+		return nil, err
+	}
+	if task.LeaseToken, err = decodeID(leaseToken); err != nil {
+		return nil, err
+	}
+	if task.AgentID, err = decodeID(agentID); err != nil {
+		return nil, err
+	}
+	if task.Precision, err = decodePrecision(prec); err != nil {
+		return nil, err
+	}
+	if task.ExactArgs, err = decodeStrings(exactArgs); err != nil {
+		return nil, err
+	}
+	task.ExactResult = decodeExact(exactResult)
+	task.LeaseExpiresAt = decodeTime(leaseExpiresAt)
+	task.CreatedAt = time.Unix(0, createdAt)
+	task.StartedAt = decodeTime(startedAt)
+	task.CompletedAt = decodeTime(completedAt)
+	return &task, nil
+}
+
+func scanIDs(rows *sql.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, parsed)
+	}
+	return ids, rows.Err()
+}
+
+// Numbers are stored as text in strconv's shortest round-trip format, which
+// unlike JSON also represents infinities and NaN.
+
+func encodeFloats(values []models.Float) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatFloat(float64(v), 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func decodeFloats(s string) ([]models.Float, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	values := make([]models.Float, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = models.Float(v)
+	}
+	return values, nil
+}
+
+func encodeResult(result *models.Float) sql.NullString {
+	if result == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strconv.FormatFloat(float64(*result), 'g', -1, 64), Valid: true}
+}
+
+func decodeResult(s sql.NullString) (*models.Float, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s.String, 64)
+	if err != nil {
+		return nil, err
+	}
+	result := models.Float(v)
+	return &result, nil
+}
+
+func encodeVariables(variables map[string]float64) (string, error) {
+	if len(variables) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(variables)
+	return string(data), err
+}
+
+func decodeVariables(s string) (map[string]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var variables map[string]float64
+	err := json.Unmarshal([]byte(s), &variables)
+	return variables, err
+}
+
+// encodePrecision stores the zero (float64) precision as an empty string.
+func encodePrecision(precision models.Precision) (string, error) {
+	if precision == (models.Precision{}) {
+		return "", nil
+	}
+	data, err := json.Marshal(precision)
+	return string(data), err
+}
+
+func decodePrecision(s string) (models.Precision, error) {
+	var precision models.Precision
+	if s == "" {
+		return precision, nil
+	}
+	err := json.Unmarshal([]byte(s), &precision)
+	return precision, err
+}
+
+// Webhooks are stored as JSON, with their status also in a column of its
+// own for the index of pending deliveries.
+
+func encodeWebhook(webhook *models.Webhook) (string, error) {
+	if webhook == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(webhook)
+	return string(data), err
+}
+
+func decodeWebhook(s string) (*models.Webhook, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var webhook models.Webhook
+	err := json.Unmarshal([]byte(s), &webhook)
+	return &webhook, err
+}
+
+func webhookStatus(webhook *models.Webhook) models.WebhookStatus {
+	if webhook == nil {
+		return ""
+	}
+	return webhook.Status
+}
+
+// Exact arguments are stored as JSON, since unresolved ones are empty
+// strings.
+
+func encodeStrings(values []string) (string, error) {
+	if values == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(values)
+	return string(data), err
+}
+
+func decodeStrings(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var values []string
+	err := json.Unmarshal([]byte(s), &values)
+	return values, err
+}
+
+func encodeExact(value *string) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *value, Valid: true}
+}
+
+func decodeExact(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func encodeIDs(ids []uuid.UUID) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = id.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeDependencies(deps []*uuid.UUID) string {
+	ids := make([]uuid.UUID, len(deps))
+	for i, dep := range deps {
+		ids[i] = *dep
+	}
+	return encodeIDs(ids)
+}
+
+func decodeIDs(s string) ([]uuid.UUID, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	ids := make([]uuid.UUID, len(parts))
+	for i, part := range parts {
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// encodeID stores uuid.Nil as NULL.
+func encodeID(id uuid.UUID) sql.NullString {
+	if id == uuid.Nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: id.String(), Valid: true}
+}
+
+func decodeID(s sql.NullString) (uuid.UUID, error) {
+	if !s.Valid {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(s.String)
+}
+
+func encodeTime(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func decodeTime(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(0, v.Int64)
+	return &t
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// TestSQLiteRecoversProcessingTasks reopens a database with a task still
+// leased, as after a crash: the task is ready again and the old lease is
+// void.
+func TestSQLiteRecoversProcessingTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := saveReadyTask(t, store)
+	leased := leaseTask(t, store, time.Hour)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.GetExpressionByID(task.ExpressionID); err != nil {
+		t.Fatalf("expression lost on reopen: %v", err)
+	}
+	stored, err := store.GetTaskByID(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.TaskStatusPending || stored.LeaseToken != uuid.Nil || stored.LeaseExpiresAt != nil {
+		t.Errorf("recovered task is %s with lease %s until %v, want PENDING without lease", stored.Status, stored.LeaseToken, stored.LeaseExpiresAt)
+	}
+
+	if _, err := store.CompleteTask(task.ID, leased.LeaseToken, completeWith(3)); !errors.Is(err, ErrLeaseMismatch) {
+		t.Errorf("completing with the lease of the previous run: got %v, want ErrLeaseMismatch", err)
+	}
+	again := leaseTask(t, store, time.Hour)
+	if again.ID != task.ID {
+		t.Errorf("leased %s after reopening, want %s", again.ID, task.ID)
+	}
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// Store persists expressions and their task DAGs and schedules ready tasks.
+// Repository is the in-memory implementation and SQLiteStore the persistent
+// one.
+type Store interface {
+	CreateExpression(id uuid.UUID, expression string, variables map[string]float64, precision models.Precision, webhook *models.Webhook) (*models.Expression, error)
+	GetExpressionByID(id uuid.UUID) (*models.Expression, error)
+	ListExpressions(query models.ExpressionQuery) (*models.ExpressionPage, error)
+	UpdateExpression(expr *models.Expression) error
+	SaveTasks(tasks []*models.Task) error
+	GetTaskByID(id uuid.UUID) (*models.Task, error)
+	UpdateTask(task *models.Task) error
+	GetNextPendingTasks(max int, agentID uuid.UUID, leaseSlack time.Duration) ([]*models.Task, error)
+	CompleteTask(id, leaseToken uuid.UUID, apply func(task *models.Task)) (*models.Task, error)
+	RequeueExpiredTasks(now time.Time) ([]uuid.UUID, error)
+	RequeueAgentTasks(agentID uuid.UUID) ([]uuid.UUID, error)
+	ReleaseTask(id, leaseToken uuid.UUID) error
+	FailExpression(expressionID uuid.UUID, taskErr *models.TaskError) error
+	CancelExpression(expressionID uuid.UUID) (*models.Expression, error)
+	GetTasksByExpressionID(expressionID uuid.UUID) ([]*models.Task, error)
+	GetExpressionProgress(expressionID uuid.UUID) (models.Progress, error)
+	CheckExpressionCompletion(expressionID uuid.UUID) error
+	RecordWebhookAttempt(expressionID uuid.UUID, attempt models.WebhookAttempt, status models.WebhookStatus) error
+	GetPendingWebhooks() ([]*models.Expression, error)
+	Close() error
+}
+
+const (
+	BackendMemory = "memory"
+	BackendSQLite = "sqlite"
+)
+
+// Open returns the store for the given backend. For SQLite, dsn is the path
+// of the database file.
+func Open(backend, dsn string) (Store, error) {
+	switch backend {
+	case BackendMemory:
+		return NewRepository(), nil
+	case BackendSQLite:
+		return NewSQLiteStore(dsn)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
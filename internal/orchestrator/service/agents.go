@@ -0,0 +1,183 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+var (
+	// ErrAgentNotFound is returned for agents that are not registered or
+	// were declared dead.
+	ErrAgentNotFound = errors.New("agent not found")
+	// ErrAgentDraining is returned when an agent that is draining asks for
+	// tasks.
+	ErrAgentDraining = errors.New("agent is draining")
+)
+
+// agentRegistry tracks the registered agents and the tasks leased to them.
+// It lives in memory only: after a restart agents register again.
+type agentRegistry struct {
+	mutex  sync.Mutex
+	agents map[uuid.UUID]*models.Agent
+	// owners maps each in-flight task to the agent it is leased to.
+	owners map[uuid.UUID]uuid.UUID
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{
+		agents: make(map[uuid.UUID]*models.Agent),
+		owners: make(map[uuid.UUID]uuid.UUID),
+	}
+}
+
+// register adds an agent, or revives it with its counters kept if it
+// registered before.
+func (r *agentRegistry) register(req models.RegisterAgentRequest, now time.Time) *models.Agent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent, exists := r.agents[req.ID]
+	if !exists {
+		agent = &models.Agent{ID: req.ID, RegisteredAt: now}
+		r.agents[req.ID] = agent
+	}
+	agent.Hostname = req.Hostname
+	agent.Version = req.Version
+	agent.WorkerCount = req.WorkerCount
+	agent.Operations = req.Operations
+	agent.Status = models.AgentStatusAlive
+	agent.LastSeenAt = now
+
+	registered := *agent
+	return &registered
+}
+
+func (r *agentRegistry) heartbeat(id uuid.UUID, now time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent, exists := r.agents[id]
+	if !exists || agent.Status == models.AgentStatusDead {
+		return ErrAgentNotFound
+	}
+	agent.LastSeenAt = now
+	return nil
+}
+
+// drain marks an agent as draining and returns a copy of it.
+func (r *agentRegistry) drain(id uuid.UUID) (*models.Agent, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent, exists := r.agents[id]
+	if !exists || agent.Status == models.AgentStatusDead {
+		return nil, ErrAgentNotFound
+	}
+	agent.Status = models.AgentStatusDraining
+
+	drained := *agent
+	return &drained, nil
+}
+
+func (r *agentRegistry) draining(id uuid.UUID) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent, exists := r.agents[id]
+	return exists && agent.Status == models.AgentStatusDraining
+}
+
+// leased records tasks handed to an agent. Tasks leased to unknown or dead
+// agents are not tracked.
+func (r *agentRegistry) leased(agentID uuid.UUID, tasks []*models.Task) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists || agent.Status == models.AgentStatusDead {
+		return
+	}
+	for _, task := range tasks {
+		r.owners[task.ID] = agentID
+		agent.InFlightTasks++
+	}
+}
+
+// finished records the outcome reported for a task: completed, failed, or
+// neither if the result was discarded.
+func (r *agentRegistry) finished(taskID uuid.UUID, completed, failed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agent := r.release(taskID)
+	if agent == nil {
+		return
+	}
+	if completed {
+		agent.CompletedTasks++
+	}
+	if failed {
+		agent.FailedTasks++
+	}
+}
+
+// released stops tracking tasks whose leases were revoked.
+func (r *agentRegistry) released(taskIDs []uuid.UUID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, id := range taskIDs {
+		r.release(id)
+	}
+}
+
+// release removes a task from its owner's in-flight tasks and returns the
+// owner, or nil if the task is not tracked. The caller must hold the mutex.
+func (r *agentRegistry) release(taskID uuid.UUID) *models.Agent {
+	agentID, exists := r.owners[taskID]
+	if !exists {
+		return nil
+	}
+	delete(r.owners, taskID)
+
+	agent := r.agents[agentID]
+	agent.InFlightTasks--
+	return agent
+}
+
+// expire declares dead every agent not seen since deadline and returns
+// their IDs.
+func (r *agentRegistry) expire(deadline time.Time) []uuid.UUID {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var dead []uuid.UUID
+	for id, agent := range r.agents {
+		if agent.Status != models.AgentStatusDead && agent.LastSeenAt.Before(deadline) {
+			agent.Status = models.AgentStatusDead
+			dead = append(dead, id)
+		}
+	}
+	return dead
+}
+
+// list returns copies of all agents, oldest registration first.
+func (r *agentRegistry) list() []*models.Agent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	agents := make([]*models.Agent, 0, len(r.agents))
+	for _, agent := range r.agents {
+		listed := *agent
+		agents = append(agents, &listed)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].RegisteredAt.Before(agents[j].RegisteredAt)
+	})
+	return agents
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+)
+
+func findAgent(t *testing.T, s *Service, id uuid.UUID) *models.Agent {
+	t.Helper()
+	for _, agent := range s.GetAgents() {
+		if agent.ID == id {
+			return agent
+		}
+	}
+	t.Fatalf("agent %s not listed", id)
+	return nil
+}
+
+func TestDrainAgent(t *testing.T) {
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+	defer s.Shutdown()
+	agent := s.RegisterAgent(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1})
+
+	polled := make(chan error, 1)
+	go func() {
+		_, err := s.GetNextTask(context.Background(), agent.ID, time.Minute)
+		polled <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	drained, err := s.DrainAgent(agent.ID)
+	if err != nil || drained.Status != models.AgentStatusDraining {
+		t.Fatalf("drain: %v, %v", drained, err)
+	}
+	select {
+	case err := <-polled:
+		if !errors.Is(err, ErrAgentDraining) {
+			t.Errorf("pending poll: got %v, want ErrAgentDraining", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending poll not woken by the drain")
+	}
+
+	if _, err := s.CalculateExpression(models.CalculateRequest{Expression: "1 + 2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetNextTask(context.Background(), agent.ID, 0); !errors.Is(err, ErrAgentDraining) {
+		t.Errorf("poll after the drain: got %v, want ErrAgentDraining", err)
+	}
+	// A draining agent keeps sending heartbeats while it finishes its tasks.
+	if err := s.HeartbeatAgent(agent.ID); err != nil {
+		t.Errorf("heartbeat while draining: %v", err)
+	}
+	if _, err := s.DrainAgent(uuid.New()); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("draining an unknown agent: got %v, want ErrAgentNotFound", err)
+	}
+}
+
+// TestAgentHeartbeatExpiry declares dead an agent that stopped sending
+// heartbeats: its task goes to another agent, and it has to register again.
+func TestAgentHeartbeatExpiry(t *testing.T) {
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+	defer s.Shutdown()
+
+	now := time.Now()
+	silent := s.agents.register(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1}, now.Add(-2*s.agentTimeout))
+	alive := s.agents.register(models.RegisterAgentRequest{ID: uuid.New(), WorkerCount: 1}, now)
+
+	if _, err := s.CalculateExpression(models.CalculateRequest{Expression: "1 + 2"}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := s.GetNextTask(context.Background(), silent.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inFlight := findAgent(t, s, silent.ID).InFlightTasks; inFlight != 1 {
+		t.Errorf("%d tasks in flight, want 1", inFlight)
+	}
+
+	s.expireAgents(now)
+	if status := findAgent(t, s, silent.ID).Status; status != models.AgentStatusDead {
+		t.Errorf("silent agent is %s, want %s", status, models.AgentStatusDead)
+	}
+	if status := findAgent(t, s, alive.ID).Status; status != models.AgentStatusAlive {
+		t.Errorf("live agent is %s, want %s", status, models.AgentStatusAlive)
+	}
+	if inFlight := findAgent(t, s, silent.ID).InFlightTasks; inFlight != 0 {
+		t.Errorf("dead agent holds %d tasks, want 0", inFlight)
+	}
+
+	requeued, err := s.GetNextTask(context.Background(), alive.ID, 0)
+	if err != nil || requeued.ID != task.ID {
+		t.Fatalf("leased %v, %v, want the dead agent's task %s", requeued, err, task.ID)
+	}
+	late := models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken, Result: 3}
+	if err := s.SubmitTaskResult(late); !errors.Is(err, repository.ErrLeaseMismatch) {
+		t.Errorf("dead agent's result: got %v, want ErrLeaseMismatch", err)
+	}
+
+	if err := s.HeartbeatAgent(silent.ID); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("heartbeat of a dead agent: got %v, want ErrAgentNotFound", err)
+	}
+	if revived := s.RegisterAgent(models.RegisterAgentRequest{ID: silent.ID, WorkerCount: 1}); revived.Status != models.AgentStatusAlive {
+		t.Errorf("registering again: agent is %s, want %s", revived.Status, models.AgentStatusAlive)
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind by before
+// it is dropped.
+const eventBufferSize = 256
+
+// eventBus fans events out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full is dropped and its channel closed, as is
+// every subscriber once the bus is closed.
+type eventBus struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[*subscription]struct{}
+	closed      bool
+}
+
+type subscription struct {
+	events chan models.Event
+	filter models.EventFilter
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[*subscription]struct{})}
+}
+
+// active reports whether anyone is subscribed, so that publishers can skip
+// building events no one receives.
+func (b *eventBus) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+func (b *eventBus) subscribe(filter models.EventFilter) (<-chan models.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscription{events: make(chan models.Event, eventBufferSize), filter: filter}
+	if b.closed {
+		close(sub.events)
+		return sub.events, func() {}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub.events, func() { b.unsubscribe(sub) }
+}
+
+func (b *eventBus) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish builds an event, numbers it and delivers it to the matching
+// subscribers. The event is built under the lock, so that events are
+// numbered and delivered in the order of the states they report.
+func (b *eventBus) publish(build func() (models.Event, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event, err := build()
+	if err != nil {
+		return err
+	}
+	b.seq++
+	event.ID = b.seq
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return nil
+}
+
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// SubscribeEvents streams the events passing the filter. The channel is
+// closed when the returned function is called, when the subscriber falls
+// too far behind and on Shutdown.
+func (s *Service) SubscribeEvents(filter models.EventFilter) (<-chan models.Event, func()) {
+	return s.events.subscribe(filter)
+}
+
+// ExpressionEvent returns the current state of an expression as an
+// expression event, for subscribers to start from. It is not numbered.
+func (s *Service) ExpressionEvent(id uuid.UUID) (models.Event, error) {
+	return s.newEvent(id, uuid.Nil)
+}
+
+// newEvent builds the event for a change to an expression, or to its task
+// taskID if set, from their state in the store.
+func (s *Service) newEvent(expressionID, taskID uuid.UUID) (models.Event, error) {
+	expr, err := s.repo.GetExpressionByID(expressionID)
+	if err != nil {
+		return models.Event{}, err
+	}
+	progress, err := s.repo.GetExpressionProgress(expressionID)
+	if err != nil {
+		return models.Event{}, err
+	}
+
+	event := models.Event{
+		Type:         models.EventExpression,
+		ExpressionID: expr.ID,
+		Status:       expr.Status,
+		Progress:     progress,
+		Result:       expr.Result,
+		ExactResult:  expr.ExactResult,
+		Error:        expr.Error,
+		ErrorCode:    expr.ErrorCode,
+		Time:         time.Now(),
+	}
+	if taskID != uuid.Nil {
+		task, err := s.repo.GetTaskByID(taskID)
+		if err != nil {
+			return models.Event{}, err
+		}
+		event.Type = models.EventTask
+		event.Task = newTaskEvent(task)
+	}
+	return event, nil
+}
+
+func newTaskEvent(task *models.Task) *models.TaskEvent {
+	event := &models.TaskEvent{
+		ID:          task.ID,
+		Operation:   task.Operation,
+		Status:      task.Status,
+		Result:      task.Result,
+		ExactResult: task.ExactResult,
+		Error:       task.Error,
+		ErrorCode:   task.ErrorCode,
+	}
+	if task.AgentID != uuid.Nil {
+		agentID := task.AgentID
+		event.AgentID = &agentID
+	}
+	return event
+}
+
+// publish announces a change to an expression, or to its task taskID if
+// set. Events are only built when someone is subscribed.
+func (s *Service) publish(expressionID, taskID uuid.UUID) {
+	if !s.events.active() {
+		return
+	}
+	err := s.events.publish(func() (models.Event, error) {
+		return s.newEvent(expressionID, taskID)
+	})
+	if err != nil {
+		log.Printf("Failed to build event for expression %s: %v", expressionID, err)
+	}
+}
+
+// publishTasks announces changes to tasks known by ID only, such as
+// re-queued ones.
+func (s *Service) publishTasks(ids []uuid.UUID) {
+	if !s.events.active() {
+		return
+	}
+	for _, id := range ids {
+		task, err := s.repo.GetTaskByID(id)
+		if err != nil {
+			log.Printf("Failed to build event for task %s: %v", id, err)
+			continue
+		}
+		s.publish(task.ExpressionID, task.ID)
+	}
+}
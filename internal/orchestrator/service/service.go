@@ -1,36 +1,105 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
 	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
-	"github.com/google/uuid"
 )
 
+// ErrShuttingDown is returned to agents asking for tasks once the
+// orchestrator has begun shutting down.
+var ErrShuttingDown = errors.New("orchestrator is shutting down")
+
 type Service struct {
-	repo      *repository.Repository
-	calculator *calculator.Calculator
+	repo         repository.Store
+	calculator   *calculator.Calculator
+	leaseSlack   time.Duration
+	reapInterval time.Duration
+	// tasksReady is broadcast whenever tasks may have become ready, waking
+	// long-polling agents.
+	tasksReady *signal
+	// leasesRevoked is broadcast whenever leases are released by their
+	// agents or re-queued, which frees the slots the tasks held.
+	leasesRevoked *signal
+	agents        *agentRegistry
+	// agentTimeout is how long an agent may go without a heartbeat before
+	// it is declared dead.
+	agentTimeout time.Duration
+	// events carries expression and task changes to subscribers.
+	events   *eventBus
+	webhooks *webhookSender
+	// stopping is closed by Shutdown to release long-polling agents.
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
-func NewService(repo *repository.Repository, calc *calculator.Calculator) *Service {
+func NewService(repo repository.Store, calc *calculator.Calculator) *Service {
+	leaseSlack, err := strconv.Atoi(getEnvOrDefault("LEASE_SLACK_MS", "5000"))
+	if err != nil || leaseSlack < 0 {
+		leaseSlack = 5000
+	}
+	reapInterval, err := strconv.Atoi(getEnvOrDefault("LEASE_REAP_INTERVAL_MS", "1000"))
+	if err != nil || reapInterval < 1 {
+		reapInterval = 1000
+	}
+	agentTimeout, err := strconv.Atoi(getEnvOrDefault("AGENT_TIMEOUT_MS", "15000"))
+	if err != nil || agentTimeout < 1 {
+		agentTimeout = 15000
+	}
+
 	return &Service{
-		repo:      repo,
-		calculator: calc,
+		repo:          repo,
+		calculator:    calc,
+		leaseSlack:    time.Duration(leaseSlack) * time.Millisecond,
+		reapInterval:  time.Duration(reapInterval) * time.Millisecond,
+		tasksReady:    newSignal(),
+		leasesRevoked: newSignal(),
+		agents:        newAgentRegistry(),
+		agentTimeout:  time.Duration(agentTimeout) * time.Millisecond,
+		events:        newEventBus(),
+		webhooks:      newWebhookSender(),
+		stopping:      make(chan struct{}),
+	}
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
+	return value
 }
 
-func (s *Service) CalculateExpression(expression string) (*models.Expression, error) {
-	expr, err := s.repo.CreateExpression(expression)
+func (s *Service) CalculateExpression(req models.CalculateRequest) (*models.Expression, error) {
+	precision, err := models.NewPrecision(req.PrecisionRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	tasks, err := s.calculator.ProcessExpression(expression, expr.ID)
+	webhook, err := s.webhooks.newWebhook(req.CallbackURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plan the tasks before storing anything, so that rejected submissions
+	// leave no expression behind.
+	id := uuid.New()
+	tasks, err := s.calculator.ProcessExpression(req.Expression, req.Variables, precision, id)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := s.repo.CreateExpression(id, req.Expression, req.Variables, precision, webhook)
 	if err != nil {
-		expr.Status = models.StatusError
-		expr.Error = err.Error()
-		s.repo.UpdateExpression(expr)
 		return nil, err
 	}
 
@@ -38,8 +107,12 @@ func (s *Service) CalculateExpression(expression string) (*models.Expression, er
 	if err != nil {
 		return nil, err
 	}
+	s.tasksReady.broadcast()
 
-	s.repo.CheckExpressionCompletion(expr.ID)
+	if err := s.repo.CheckExpressionCompletion(expr.ID); err != nil {
+		return nil, err
+	}
+	s.expressionChanged(expr.ID)
 
 	return expr, nil
 }
@@ -48,28 +121,305 @@ func (s *Service) GetExpressionByID(id uuid.UUID) (*models.Expression, error) {
 	return s.repo.GetExpressionByID(id)
 }
 
-func (s *Service) GetAllExpressions() []*models.Expression {
-	return s.repo.GetAllExpressions()
+// WaitForExpression waits up to timeout for an expression to finish, woken
+// by its events, and returns it as last read: finished, or still running if
+// the timeout expires, ctx is cancelled or the orchestrator shuts down
+// first.
+func (s *Service) WaitForExpression(ctx context.Context, id uuid.UUID, timeout time.Duration) (*models.Expression, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	// Subscribe before reading so that the change finishing the expression
+	// is not missed in between.
+	filter := models.EventFilter{ExpressionID: id}
+	events, cancel := s.events.subscribe(filter)
+	defer func() { cancel() }()
+
+	for {
+		expr, err := s.repo.GetExpressionByID(id)
+		if err != nil || expr.Status.IsFinal() {
+			return expr, err
+		}
+
+		select {
+		case _, ok := <-events:
+			if !ok {
+				// The subscription fell behind, or Shutdown closed the bus.
+				select {
+				case <-s.stopping:
+					return expr, nil
+				default:
+				}
+				cancel()
+				events, cancel = s.events.subscribe(filter)
+			}
+		case <-deadline.C:
+			return s.repo.GetExpressionByID(id)
+		case <-ctx.Done():
+			return expr, nil
+		}
+	}
 }
 
-func (s *Service) GetNextTask() (*models.Task, error) {
-	return s.repo.GetNextPendingTask()
+// GetExpressionTasks returns the task DAG of an expression, in creation
+// order.
+func (s *Service) GetExpressionTasks(id uuid.UUID) ([]*models.Task, error) {
+	return s.repo.GetTasksByExpressionID(id)
 }
 
-func (s *Service) UpdateTaskResult(id uuid.UUID, result float64) error {
-	task, err := s.repo.GetTaskByID(id)
+// ListExpressions returns a page of the expressions selected by the query.
+func (s *Service) ListExpressions(query models.ExpressionQuery) (*models.ExpressionPage, error) {
+	return s.repo.ListExpressions(query)
+}
+
+// GetNextTask leases the next ready task to the given agent, which may be
+// uuid.Nil for unregistered agents. If none is ready it waits up to wait for
+// one, returning repository.ErrNoTasksAvailable on timeout or the context
+// error if ctx is cancelled first.
+func (s *Service) GetNextTask(ctx context.Context, agentID uuid.UUID, wait time.Duration) (*models.Task, error) {
+	tasks, err := s.GetNextTasks(ctx, agentID, 1, wait)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return tasks[0], nil
+}
+
+// GetNextTasks leases up to max ready tasks, waiting for at least one like
+// GetNextTask. After Shutdown it returns ErrShuttingDown without leasing.
+func (s *Service) GetNextTasks(ctx context.Context, agentID uuid.UUID, max int, wait time.Duration) ([]*models.Task, error) {
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		ready := s.tasksReady.wait()
+
+		select {
+		case <-s.stopping:
+			return nil, ErrShuttingDown
+		default:
+		}
+		if s.agents.draining(agentID) {
+			return nil, ErrAgentDraining
+		}
+
+		tasks, err := s.repo.GetNextPendingTasks(max, agentID, s.leaseSlack)
+		if err == nil {
+			s.agents.leased(agentID, tasks)
+			for _, task := range tasks {
+				s.publish(task.ExpressionID, task.ID)
+			}
+		}
+		if !errors.Is(err, repository.ErrNoTasksAvailable) {
+			return tasks, err
+		}
+
+		select {
+		case <-ready:
+		case <-s.stopping:
+			return nil, ErrShuttingDown
+		case <-timeout.C:
+			return nil, repository.ErrNoTasksAvailable
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
+}
 
-	s.calculator.UpdateTaskResult(task, result)
-	
-	err = s.repo.UpdateTask(task)
+// UpdateTaskResult records a task's result; exact is its lossless form for
+// tasks evaluated in a math/big precision.
+func (s *Service) UpdateTaskResult(id, leaseToken uuid.UUID, result models.Float, exact string) error {
+	task, err := s.repo.CompleteTask(id, leaseToken, func(task *models.Task) {
+		s.calculator.UpdateTaskResult(task, result, exact)
+	})
 	if err != nil {
 		return err
 	}
+	if task.Status == models.TaskStatusError {
+		// The result was rejected, e.g. an exact result that does not parse.
+		err := s.repo.FailExpression(task.ExpressionID, &models.TaskError{Code: task.ErrorCode, Message: task.Error})
+		s.publish(task.ExpressionID, task.ID)
+		s.expressionChanged(task.ExpressionID)
+		return err
+	}
+	s.tasksReady.broadcast()
 
-	s.repo.CheckExpressionCompletion(task.ExpressionID)
-	
+	if err := s.repo.CheckExpressionCompletion(task.ExpressionID); err != nil {
+		return err
+	}
+	s.publish(task.ExpressionID, task.ID)
+	if len(task.Dependents) == 0 {
+		// The root task's result completes the expression.
+		s.expressionChanged(task.ExpressionID)
+	}
 	return nil
 }
+
+func (s *Service) CancelExpression(id uuid.UUID) (*models.Expression, error) {
+	expr, err := s.repo.CancelExpression(id)
+	if err == nil {
+		s.expressionChanged(id)
+	}
+	return expr, err
+}
+
+// FailTask records an agent-reported failure of a task and propagates it to
+// the parent expression.
+func (s *Service) FailTask(id, leaseToken uuid.UUID, taskErr *models.TaskError) error {
+	if taskErr.Code == "" {
+		taskErr.Code = models.ErrorCodeExecutionFailed
+	}
+
+	task, err := s.repo.CompleteTask(id, leaseToken, func(task *models.Task) {
+		s.calculator.FailTask(task, taskErr)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.FailExpression(task.ExpressionID, taskErr)
+	s.publish(task.ExpressionID, task.ID)
+	s.expressionChanged(task.ExpressionID)
+	return err
+}
+
+// SubmitTaskResult records the outcome reported by an agent: a failure if
+// req.Error is set, the result otherwise.
+func (s *Service) SubmitTaskResult(req models.TaskResultRequest) error {
+	var err error
+	if req.Error != nil {
+		err = s.FailTask(req.ID, req.LeaseToken, req.Error)
+	} else {
+		err = s.UpdateTaskResult(req.ID, req.LeaseToken, req.Result, req.ExactResult)
+	}
+
+	switch {
+	case err == nil:
+		s.agents.finished(req.ID, req.Error == nil, req.Error != nil)
+	case errors.Is(err, repository.ErrTaskCancelled):
+		s.agents.finished(req.ID, false, false)
+	}
+	return err
+}
+
+// ReleaseTasks returns tasks that an agent gives up without results to the
+// ready queue and reports how many were released. Tasks whose leases the
+// caller no longer holds are skipped.
+func (s *Service) ReleaseTasks(leases []models.TaskLease) (int, error) {
+	var released []uuid.UUID
+	for _, lease := range leases {
+		err := s.repo.ReleaseTask(lease.ID, lease.LeaseToken)
+		if errors.Is(err, repository.ErrLeaseMismatch) || errors.Is(err, repository.ErrTaskCancelled) ||
+			errors.Is(err, repository.ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return len(released), err
+		}
+		released = append(released, lease.ID)
+	}
+
+	if len(released) > 0 {
+		s.agents.released(released)
+		s.tasksReady.broadcast()
+		s.leasesRevoked.broadcast()
+		s.publishTasks(released)
+	}
+	return len(released), nil
+}
+
+// LeasesRevoked returns a channel that is closed the next time leases are
+// released or re-queued. Obtain it before checking leases with LeaseHeld so
+// that a revocation in between is not missed.
+func (s *Service) LeasesRevoked() <-chan struct{} {
+	return s.leasesRevoked.wait()
+}
+
+// LeaseHeld reports whether a task is still leased under leaseToken.
+func (s *Service) LeaseHeld(id, leaseToken uuid.UUID) bool {
+	task, err := s.repo.GetTaskByID(id)
+	return err == nil && task.Status == models.TaskStatusProcessing && task.LeaseToken == leaseToken
+}
+
+// RegisterAgent adds an agent to the fleet, or revives one that registered
+// before.
+func (s *Service) RegisterAgent(req models.RegisterAgentRequest) *models.Agent {
+	return s.agents.register(req, time.Now())
+}
+
+// HeartbeatAgent marks an agent as seen. It returns ErrAgentNotFound if the
+// agent must register (again).
+func (s *Service) HeartbeatAgent(id uuid.UUID) error {
+	return s.agents.heartbeat(id, time.Now())
+}
+
+// DrainAgent tells an agent to stop taking work. Its pending and future task
+// requests fail with ErrAgentDraining, which the agent takes as the signal
+// to finish what it holds and shut down.
+func (s *Service) DrainAgent(id uuid.UUID) (*models.Agent, error) {
+	agent, err := s.agents.drain(id)
+	if err != nil {
+		return nil, err
+	}
+	// Wake the agent's long-polls so that they notice.
+	s.tasksReady.broadcast()
+	return agent, nil
+}
+
+func (s *Service) GetAgents() []*models.Agent {
+	return s.agents.list()
+}
+
+// Shutdown stops handing out tasks and releases the agents waiting for
+// them, and ends event subscriptions, so that servers can drain their
+// in-flight requests and streams.
+func (s *Service) Shutdown() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+		s.events.close()
+	})
+}
+
+// RunLeaseReaper periodically returns tasks with expired leases, and the
+// tasks of agents that missed their heartbeats, to the pending queue until
+// ctx is cancelled.
+func (s *Service) RunLeaseReaper(ctx context.Context) {
+	ticker := time.NewTicker(s.reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			requeued, err := s.repo.RequeueExpiredTasks(now)
+			if err != nil {
+				log.Printf("Failed to re-queue expired tasks: %v", err)
+			} else if len(requeued) > 0 {
+				log.Printf("Re-queued %d tasks with expired leases", len(requeued))
+				s.agents.released(requeued)
+				s.tasksReady.broadcast()
+				s.leasesRevoked.broadcast()
+				s.publishTasks(requeued)
+			}
+
+			s.expireAgents(now)
+		}
+	}
+}
+
+// expireAgents declares dead the agents not seen within agentTimeout and
+// re-queues their tasks.
+func (s *Service) expireAgents(now time.Time) {
+	for _, id := range s.agents.expire(now.Add(-s.agentTimeout)) {
+		requeued, err := s.repo.RequeueAgentTasks(id)
+		if err != nil {
+			log.Printf("Failed to re-queue tasks of dead agent %s: %v", id, err)
+			continue
+		}
+		log.Printf("Agent %s missed its heartbeats; re-queued %d tasks", id, len(requeued))
+		s.agents.released(requeued)
+		s.tasksReady.broadcast()
+		s.leasesRevoked.broadcast()
+		s.publishTasks(requeued)
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+)
+
+// runAgent leases and computes tasks of s until ctx is cancelled.
+func runAgent(ctx context.Context, s *Service, calc *calculator.Calculator) {
+	agentID := uuid.New()
+	for ctx.Err() == nil {
+		task, err := s.GetNextTask(ctx, agentID, 100*time.Millisecond)
+		if err != nil {
+			continue
+		}
+		result := models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken}
+		value, err := calc.ExecuteOperation(task.Operation, models.Float64s(task.Args))
+		if err != nil {
+			result.Error = models.NewTaskError(models.ErrorCodeExecutionFailed, "%v", err)
+		} else {
+			result.Result = models.Float(value)
+		}
+		s.SubmitTaskResult(result)
+	}
+}
+
+func TestWaitForExpressionWhileComputing(t *testing.T) {
+	calc := calculator.NewCalculator()
+	s := NewService(repository.NewRepository(), calc)
+	defer s.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var agents sync.WaitGroup
+	for range 4 {
+		agents.Add(1)
+		go func() {
+			defer agents.Done()
+			runAgent(ctx, s, calc)
+		}()
+	}
+	defer func() {
+		cancel()
+		agents.Wait()
+	}()
+
+	var waiters sync.WaitGroup
+	for range 8 {
+		waiters.Add(1)
+		go func() {
+			defer waiters.Done()
+			expr, err := s.CalculateExpression(models.CalculateRequest{Expression: "(1 + 2) * (3 + 4) - 10 / 5"})
+			if err != nil {
+				t.Errorf("CalculateExpression: %v", err)
+				return
+			}
+			expr, err = s.WaitForExpression(context.Background(), expr.ID, 10*time.Second)
+			if err != nil {
+				t.Errorf("WaitForExpression: %v", err)
+				return
+			}
+			if expr.Status != models.StatusCompleted || expr.Result == nil || *expr.Result != 19 {
+				t.Errorf("expression %s: status %s, result %v, want COMPLETED 19", expr.ID, expr.Status, expr.Result)
+			}
+		}()
+	}
+	waiters.Wait()
+}
+
+func TestLeaseSlackFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"250", 250 * time.Millisecond},
+		{"0", 0},
+		{"-1", 5 * time.Second},
+		{"soon", 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("LEASE_SLACK_MS", tt.value)
+		s := NewService(repository.NewRepository(), calculator.NewCalculator())
+		if s.leaseSlack != tt.want {
+			t.Errorf("LEASE_SLACK_MS=%q: lease slack %s, want %s", tt.value, s.leaseSlack, tt.want)
+		}
+	}
+}
+
+// TestLateResultAfterLeaseExpiry lets a lease expire while its agent is
+// still computing: the reaper hands the task to another agent and the late
+// result is rejected.
+func TestLateResultAfterLeaseExpiry(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "0")
+	t.Setenv("LEASE_SLACK_MS", "0")
+	t.Setenv("LEASE_REAP_INTERVAL_MS", "1")
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+	defer s.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunLeaseReaper(ctx)
+
+	expr, err := s.CalculateExpression(models.CalculateRequest{Expression: "1 + 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := s.GetNextTask(ctx, uuid.New(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reaper re-queues the expired lease and wakes the waiting agent.
+	current, err := s.GetNextTask(ctx, uuid.New(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("task not re-queued after its lease expired: %v", err)
+	}
+	if current.ID != slow.ID {
+		t.Fatalf("leased %s, want the expired task %s", current.ID, slow.ID)
+	}
+
+	late := models.TaskResultRequest{ID: slow.ID, LeaseToken: slow.LeaseToken, Result: -1}
+	if err := s.SubmitTaskResult(late); !errors.Is(err, repository.ErrLeaseMismatch) {
+		t.Errorf("late result: got %v, want ErrLeaseMismatch", err)
+	}
+	result := models.TaskResultRequest{ID: current.ID, LeaseToken: current.LeaseToken, Result: 3}
+	if err := s.SubmitTaskResult(result); err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err = s.GetExpressionByID(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.Status != models.StatusCompleted || expr.Result == nil || *expr.Result != 3 {
+		t.Errorf("expression is %s with result %v, want COMPLETED 3", expr.Status, expr.Result)
+	}
+}
+
+// TestFailedTaskFailsExpression reports a failure for one operand of a
+// product: the expression fails with its error and the other tasks are
+// cancelled, so the sibling's result is discarded.
+func TestFailedTaskFailsExpression(t *testing.T) {
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+	defer s.Shutdown()
+
+	expr, err := s.CalculateExpression(models.CalculateRequest{Expression: "(1 + 2) * (3 + 4)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leased, err := s.GetNextTasks(context.Background(), uuid.New(), 2, time.Second)
+	if err != nil || len(leased) != 2 {
+		t.Fatalf("leased %d tasks: %v", len(leased), err)
+	}
+	failed, sibling := leased[0], leased[1]
+
+	taskErr := models.NewTaskError(models.ErrorCodeDomain, "operand out of range")
+	if err := s.SubmitTaskResult(models.TaskResultRequest{ID: failed.ID, LeaseToken: failed.LeaseToken, Error: taskErr}); err != nil {
+		t.Fatal(err)
+	}
+	late := models.TaskResultRequest{ID: sibling.ID, LeaseToken: sibling.LeaseToken, Result: 7}
+	if err := s.SubmitTaskResult(late); !errors.Is(err, repository.ErrTaskCancelled) {
+		t.Errorf("sibling result: got %v, want ErrTaskCancelled", err)
+	}
+
+	expr, err = s.GetExpressionByID(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.Status != models.StatusError || expr.ErrorCode != models.ErrorCodeDomain || expr.Error != taskErr.Message {
+		t.Errorf("expression is %s with error %s %q, want ERROR %s %q", expr.Status, expr.ErrorCode, expr.Error, models.ErrorCodeDomain, taskErr.Message)
+	}
+
+	tasks, err := s.GetExpressionTasks(expr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		want := models.TaskStatusCancelled
+		if task.ID == failed.ID {
+			want = models.TaskStatusError
+		} else if task.Operation == models.OperationValue {
+			continue
+		}
+		if task.Status != want {
+			t.Errorf("task %s (%s) is %s, want %s", task.ID, task.Operation, task.Status, want)
+		}
+	}
+}
+
+// pollTask long-polls for a task in the background and returns it, or nil
+// on error.
+func pollTask(s *Service, wait time.Duration) <-chan *models.Task {
+	polled := make(chan *models.Task, 1)
+	go func() {
+		task, _ := s.GetNextTask(context.Background(), uuid.New(), wait)
+		polled <- task
+	}()
+	return polled
+}
+
+// awaitTask returns the task polled, failing if the poller is not woken.
+func awaitTask(t *testing.T, polled <-chan *models.Task) *models.Task {
+	t.Helper()
+	select {
+	case task := <-polled:
+		if task == nil {
+			t.Fatal("poll ended without a task")
+		}
+		return task
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller not woken")
+		return nil
+	}
+}
+
+func TestLongPollWakesUp(t *testing.T) {
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+	defer s.Shutdown()
+
+	start := time.Now()
+	if _, err := s.GetNextTask(context.Background(), uuid.New(), 20*time.Millisecond); !errors.Is(err, repository.ErrNoTasksAvailable) {
+		t.Fatalf("empty queue: got %v, want ErrNoTasksAvailable", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("returned after %s, before the wait was over", elapsed)
+	}
+
+	// A new expression wakes the poller.
+	polled := pollTask(s, time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if _, err := s.CalculateExpression(models.CalculateRequest{Expression: "-(1 + 2)"}); err != nil {
+		t.Fatal(err)
+	}
+	leaf := awaitTask(t, polled)
+
+	// So does a result that makes its dependent ready.
+	polled = pollTask(s, time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if err := s.SubmitTaskResult(models.TaskResultRequest{ID: leaf.ID, LeaseToken: leaf.LeaseToken, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if root := awaitTask(t, polled); root.Operation != models.OperationNegation {
+		t.Errorf("polled a %s task, want the NEGATION depending on the result", root.Operation)
+	}
+}
+
+func TestLongPollEndsOnShutdown(t *testing.T) {
+	s := NewService(repository.NewRepository(), calculator.NewCalculator())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.GetNextTask(context.Background(), uuid.New(), time.Minute)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Shutdown()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("got %v, want ErrShuttingDown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller not released by Shutdown")
+	}
+}
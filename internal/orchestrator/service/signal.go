@@ -0,0 +1,28 @@
+package service
+
+import "sync"
+
+// signal wakes every goroutine waiting on it when broadcast. Waiters must
+// obtain the channel before checking their condition so that a broadcast in
+// between is not missed.
+type signal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newSignal() *signal {
+	return &signal{ch: make(chan struct{})}
+}
+
+func (s *signal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *signal) broadcast() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+)
+
+// ErrWebhooksDisabled is returned for callback URLs while there is no
+// WEBHOOK_SECRET to sign deliveries with.
+var ErrWebhooksDisabled = errors.New("callback_url is not supported: WEBHOOK_SECRET is not set")
+
+// maxWebhookBackoff caps the delay between delivery attempts.
+const maxWebhookBackoff = 5 * time.Minute
+
+// Headers of webhook deliveries. The signature is the hex HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed with WEBHOOK_SECRET.
+const (
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookAttemptHeader   = "X-Webhook-Attempt"
+)
+
+// webhookSender posts results to callback URLs, retrying with exponential
+// backoff: the nth retry waits backoff·2ⁿ⁻¹, up to maxWebhookBackoff.
+type webhookSender struct {
+	client      *http.Client
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+	// inFlight holds the expressions being delivered, so that each is
+	// delivered once.
+	inFlight sync.Map
+}
+
+func newWebhookSender() *webhookSender {
+	maxAttempts, err := strconv.Atoi(getEnvOrDefault("WEBHOOK_MAX_ATTEMPTS", "5"))
+	if err != nil || maxAttempts < 1 {
+		maxAttempts = 5
+	}
+	backoff, err := strconv.Atoi(getEnvOrDefault("WEBHOOK_BACKOFF_MS", "1000"))
+	if err != nil || backoff < 1 {
+		backoff = 1000
+	}
+	timeout, err := strconv.Atoi(getEnvOrDefault("WEBHOOK_TIMEOUT_MS", "10000"))
+	if err != nil || timeout < 1 {
+		timeout = 10000
+	}
+
+	sender := &webhookSender{
+		client:      &http.Client{Timeout: time.Duration(timeout) * time.Millisecond},
+		maxAttempts: maxAttempts,
+		backoff:     time.Duration(backoff) * time.Millisecond,
+	}
+	if secret := getEnvOrDefault("WEBHOOK_SECRET", ""); secret != "" {
+		sender.secret = []byte(secret)
+	}
+	return sender
+}
+
+// newWebhook returns the webhook of a new expression, or nil if it has no
+// callback URL.
+func (w *webhookSender) newWebhook(callbackURL string) (*models.Webhook, error) {
+	if callbackURL == "" {
+		return nil, nil
+	}
+	if w.secret == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	return models.NewWebhook(callbackURL)
+}
+
+// delay returns how long to wait after the given failed attempt. The
+// doubling stops at maxWebhookBackoff, so it cannot overflow.
+func (w *webhookSender) delay(attempt int) time.Duration {
+	delay := w.backoff
+	for i := 1; i < attempt && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxWebhookBackoff)
+}
+
+// send posts the body once and reports the attempt, which succeeded if the
+// response status is 2xx.
+func (w *webhookSender) send(ctx context.Context, url string, body []byte, attempt int) (models.WebhookAttempt, bool) {
+	record := models.WebhookAttempt{Attempt: attempt, Time: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+		return record, false
+	}
+	timestamp := strconv.FormatInt(record.Time.Unix(), 10)
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(webhookAttemptHeader, strconv.Itoa(attempt))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		record.Error = err.Error()
+		return record, false
+	}
+	resp.Body.Close()
+
+	record.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		record.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+		return record, false
+	}
+	return record, true
+}
+
+// expressionChanged announces a change of an expression's status and, once
+// it has finished, delivers its webhook.
+func (s *Service) expressionChanged(id uuid.UUID) {
+	s.publish(id, uuid.Nil)
+
+	expr, err := s.repo.GetExpressionByID(id)
+	if err != nil {
+		log.Printf("Failed to read expression %s for its webhook: %v", id, err)
+		return
+	}
+	if expr.Status.IsFinal() && expr.Webhook != nil && expr.Webhook.Status == models.WebhookPending {
+		s.startWebhook(expr)
+	}
+}
+
+// ResumeWebhooks restarts the deliveries that were pending when the
+// orchestrator last stopped.
+func (s *Service) ResumeWebhooks() error {
+	exprs, err := s.repo.GetPendingWebhooks()
+	if err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		s.startWebhook(expr)
+	}
+	if len(exprs) > 0 {
+		log.Printf("Resumed %d pending webhook deliveries", len(exprs))
+	}
+	return nil
+}
+
+func (s *Service) startWebhook(expr *models.Expression) {
+	if _, delivering := s.webhooks.inFlight.LoadOrStore(expr.ID, struct{}{}); delivering {
+		return
+	}
+	go func() {
+		defer s.webhooks.inFlight.Delete(expr.ID)
+		s.deliverWebhook(expr.ID, expr.Webhook.URL, len(expr.Webhook.Attempts))
+	}()
+}
+
+// deliverWebhook posts the final state of an expression, as an expression
+// event, to its callback URL until it is accepted or the attempts run out,
+// recording each attempt. Shutdown interrupts it, leaving the delivery
+// pending for the next start.
+func (s *Service) deliverWebhook(id uuid.UUID, url string, previousAttempts int) {
+	event, err := s.newEvent(id, uuid.Nil)
+	if err != nil {
+		log.Printf("Failed to build webhook payload for expression %s: %v", id, err)
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for expression %s: %v", id, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for attempt := previousAttempts + 1; ; attempt++ {
+		record, ok := s.webhooks.send(ctx, url, body, attempt)
+		if ctx.Err() != nil {
+			return
+		}
+
+		status := models.WebhookPending
+		switch {
+		case ok:
+			status = models.WebhookDelivered
+		case attempt >= s.webhooks.maxAttempts:
+			status = models.WebhookFailed
+			log.Printf("Giving up webhook delivery for expression %s after %d attempts: %s", id, attempt, record.Error)
+		}
+		if err := s.repo.RecordWebhookAttempt(id, record, status); err != nil {
+			log.Printf("Failed to record webhook attempt for expression %s: %v", id, err)
+			return
+		}
+		if status != models.WebhookPending {
+			return
+		}
+
+		timer := time.NewTimer(s.webhooks.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
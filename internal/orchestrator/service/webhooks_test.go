@@ -0,0 +1,141 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/calculator"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/repository"
+)
+
+func TestWebhookDelay(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{time.Second, 1, time.Second},
+		{time.Second, 2, 2 * time.Second},
+		{time.Second, 5, 16 * time.Second},
+		{time.Second, 9, 256 * time.Second},
+		{time.Second, 10, maxWebhookBackoff},
+		{time.Second, 64, maxWebhookBackoff},
+		{time.Second, math.MaxInt, maxWebhookBackoff},
+		// A shift by 20 would overflow int64 nanoseconds.
+		{1e6 * time.Hour, 21, maxWebhookBackoff},
+		{time.Hour, 1, maxWebhookBackoff},
+	}
+
+	for _, tt := range tests {
+		w := &webhookSender{backoff: tt.backoff}
+		if got := w.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) with backoff %s = %s, want %s", tt.attempt, tt.backoff, got, tt.want)
+		}
+	}
+}
+
+// delivery is a webhook request as received by the callback server.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		// responses are the status codes returned to successive attempts.
+		responses []int
+		want      models.WebhookStatus
+	}{
+		{"retried after 5xx", []int{http.StatusServiceUnavailable, http.StatusOK}, models.WebhookDelivered},
+		{"attempts exhausted", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusInternalServerError}, models.WebhookFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const secret = "test-secret"
+			t.Setenv("WEBHOOK_SECRET", secret)
+			t.Setenv("WEBHOOK_BACKOFF_MS", "1")
+			t.Setenv("WEBHOOK_MAX_ATTEMPTS", strconv.Itoa(len(tt.responses)))
+
+			deliveries := make(chan delivery, len(tt.responses))
+			var received atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				deliveries <- delivery{header: r.Header.Clone(), body: body}
+				w.WriteHeader(tt.responses[received.Add(1)-1])
+			}))
+			defer server.Close()
+
+			s := NewService(repository.NewRepository(), calculator.NewCalculator())
+			defer s.Shutdown()
+			expr, err := s.CalculateExpression(models.CalculateRequest{Expression: "1 + 2", CallbackURL: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			task, err := s.GetNextTask(t.Context(), uuid.New(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SubmitTaskResult(models.TaskResultRequest{ID: task.ID, LeaseToken: task.LeaseToken, Result: 3}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The last attempt is recorded after its response.
+			deadline := time.Now().Add(5 * time.Second)
+			for expr.Webhook.Status == models.WebhookPending && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+				if expr, err = s.GetExpressionByID(expr.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if expr.Webhook.Status != tt.want {
+				t.Fatalf("webhook is %s, want %s", expr.Webhook.Status, tt.want)
+			}
+
+			attempts := expr.Webhook.Attempts
+			if len(attempts) != len(tt.responses) {
+				t.Fatalf("recorded %d attempts, want %d", len(attempts), len(tt.responses))
+			}
+			for i, attempt := range attempts {
+				failed := tt.responses[i] >= 300
+				if attempt.Attempt != i+1 || attempt.StatusCode != tt.responses[i] || (attempt.Error != "") != failed {
+					t.Errorf("attempt %d recorded as %+v, want status %d", i+1, attempt, tt.responses[i])
+				}
+			}
+
+			for i := range tt.responses {
+				d := <-deliveries
+				if got := d.header.Get(webhookAttemptHeader); got != strconv.Itoa(i+1) {
+					t.Errorf("delivery %d: attempt header %q", i+1, got)
+				}
+				mac := hmac.New(sha256.New, []byte(secret))
+				mac.Write([]byte(d.header.Get(webhookTimestampHeader) + "."))
+				mac.Write(d.body)
+				if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.header.Get(webhookSignatureHeader) != want {
+					t.Errorf("delivery %d: signature %q, want %q", i+1, d.header.Get(webhookSignatureHeader), want)
+				}
+
+				var event models.Event
+				if err := json.Unmarshal(d.body, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.ExpressionID != expr.ID || event.Status != models.StatusCompleted || event.Result == nil || *event.Result != 3 {
+					t.Errorf("delivery %d: payload %s", i+1, d.body)
+				}
+			}
+		})
+	}
+}
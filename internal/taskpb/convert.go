@@ -0,0 +1,106 @@
+package taskpb
+
+import (
+	"github.com/google/uuid"
+	"github.com/popvictor123/distributed-calc/internal/orchestrator/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func NewTask(task models.TaskResponse) *Task {
+	msg := &Task{
+		Id:             task.ID.String(),
+		Args:           models.Float64s(task.Args),
+		Operation:      string(task.Operation),
+		OperationTime:  int64(task.OperationTime),
+		LeaseToken:     task.LeaseToken.String(),
+		LeaseExpiresAt: timestamppb.New(task.LeaseExpiresAt),
+		ExactArgs:      task.ExactArgs,
+	}
+	if task.Precision != nil {
+		msg.Precision = &Precision{
+			Mode:     string(task.Precision.Mode),
+			Scale:    int32(task.Precision.Scale),
+			Bits:     uint32(task.Precision.Bits),
+			Digits:   int32(task.Precision.Digits),
+			Rounding: string(task.Precision.Rounding),
+		}
+	}
+	return msg
+}
+
+func (t *Task) ToModel() (*models.TaskResponse, error) {
+	id, err := uuid.Parse(t.GetId())
+	if err != nil {
+		return nil, err
+	}
+	leaseToken, err := uuid.Parse(t.GetLeaseToken())
+	if err != nil {
+		return nil, err
+	}
+
+	task := &models.TaskResponse{
+		ID:             id,
+		Args:           models.Floats(t.GetArgs()),
+		Operation:      models.OperationType(t.GetOperation()),
+		OperationTime:  int(t.GetOperationTime()),
+		LeaseToken:     leaseToken,
+		LeaseExpiresAt: t.GetLeaseExpiresAt().AsTime(),
+		ExactArgs:      t.GetExactArgs(),
+	}
+	if t.Precision != nil {
+		task.Precision = &models.Precision{
+			Mode:     models.PrecisionMode(t.Precision.GetMode()),
+			Scale:    int(t.Precision.GetScale()),
+			Bits:     uint(t.Precision.GetBits()),
+			Digits:   int(t.Precision.GetDigits()),
+			Rounding: models.RoundingMode(t.Precision.GetRounding()),
+		}
+	}
+	return task, nil
+}
+
+func NewTaskResult(result models.TaskResultRequest) *TaskResult {
+	msg := &TaskResult{
+		Id:          result.ID.String(),
+		LeaseToken:  result.LeaseToken.String(),
+		Result:      float64(result.Result),
+		ExactResult: result.ExactResult,
+	}
+	if result.Error != nil {
+		msg.Error = &TaskError{Code: string(result.Error.Code), Message: result.Error.Message}
+	}
+	return msg
+}
+
+func (r *TaskResult) ToModel() (models.TaskResultRequest, error) {
+	id, err := uuid.Parse(r.GetId())
+	if err != nil {
+		return models.TaskResultRequest{}, err
+	}
+	leaseToken, err := uuid.Parse(r.GetLeaseToken())
+	if err != nil {
+		return models.TaskResultRequest{}, err
+	}
+
+	result := models.TaskResultRequest{
+		ID:          id,
+		LeaseToken:  leaseToken,
+		Result:      models.Float(r.GetResult()),
+		ExactResult: r.GetExactResult(),
+	}
+	if r.Error != nil {
+		result.Error = &models.TaskError{
+			Code:    models.TaskErrorCode(r.Error.GetCode()),
+			Message: r.Error.GetMessage(),
+		}
+	}
+	return result, nil
+}
+
+func NewTaskResultAck(item models.TaskResultItemResponse) *TaskResultAck {
+	return &TaskResultAck{
+		Id:     item.ID.String(),
+		Status: item.Status,
+		Error:  item.Error,
+	}
+}
@@ -0,0 +1,5 @@
+// Package taskpb is the protobuf contract of the gRPC orchestrator–agent
+// protocol.
+package taskpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative task.proto